@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+const (
+	defaultAPIKeyOverlap = 24 * time.Hour
+	maxAPIKeyOverlap     = 7 * 24 * time.Hour
+)
+
+// readAPIKey loads the caller's key from the :id in the URL, writing the
+// error response and returning false if there is none.
+func (a *applicationDependencies) readAPIKey(w http.ResponseWriter, r *http.Request) (*data.APIKey, bool) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	key, err := a.apiKeyModel.Get(a.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return key, true
+}
+
+func (a *applicationDependencies) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.Filters.Page = a.getSingleIntegerParameter(query, "page", 1, v)
+	input.Filters.PageSize = a.getSingleIntegerParameter(query, "page_size", 20, v)
+	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "-created_at")
+	input.Filters.SortSafeList = []string{"id", "name", "created_at", "last_used_at", "-id", "-name", "-created_at", "-last_used_at"}
+
+	data.ValidateFilters(v, input.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	keys, metadata, err := a.apiKeyModel.GetAllForUser(a.contextGetUser(r).ID, input.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"api_keys": keys,
+		"metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// createAPIKeyHandler creates a key for the caller. The key itself is only
+// in this response.
+func (a *applicationDependencies) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	key, plaintext, err := data.NewAPIKey(user.ID, input.Name, input.Scopes, input.ExpiresAt)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateAPIKey(v, key, user)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.apiKeyModel.Insert(key, a.newAuditEvent(r, "api_key", data.AuditActionCreate))
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/api-keys/%d", key.ID))
+
+	data := envelope{"api_key": key, "key": plaintext}
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) displayAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := a.readAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	data := envelope{"api_key": key}
+	err := a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeAPIKeyHandler stops a key working at once. The key stays listed so
+// its history can still be seen.
+func (a *applicationDependencies) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := a.readAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	err := a.apiKeyModel.Revoke(key, a.newAuditEvent(r, "api_key", data.AuditActionRevoke))
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{"message": "api key successfully revoked"}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// rotateAPIKeyHandler replaces a key with a new one. The old key keeps
+// working for overlap_minutes (a day by default, at most a week) so that
+// services can be moved over to the new one.
+func (a *applicationDependencies) rotateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := a.readAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		OverlapMinutes *int `json:"overlap_minutes"`
+	}
+
+	// The body is optional.
+	if r.ContentLength != 0 {
+		err := a.readJSON(w, r, &input)
+		if err != nil {
+			a.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	overlap := defaultAPIKeyOverlap
+	if input.OverlapMinutes != nil {
+		maxMinutes := int(maxAPIKeyOverlap / time.Minute)
+
+		v := validator.New()
+		v.Check(validator.InRange(*input.OverlapMinutes, 0, maxMinutes), "overlap_minutes", "range", "min", 0, "max", maxMinutes)
+		if !v.IsEmpty() {
+			a.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		overlap = time.Duration(*input.OverlapMinutes) * time.Minute
+	}
+
+	replacement, plaintext, err := a.apiKeyModel.Rotate(key, overlap, a.newAuditEvent(r, "api_key", data.AuditActionRotate))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrAPIKeyRevoked):
+			a.apiKeyRevokedResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/api-keys/%d", replacement.ID))
+
+	data := envelope{"api_key": replacement, "key": plaintext, "replaced": key}
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// newAuditEvent describes who is making the request. The model fills in the
+// resource ID and the before/after snapshots inside its transaction.
+func (a *applicationDependencies) newAuditEvent(r *http.Request, resource, action string) *data.AuditEvent {
+	return &data.AuditEvent{
+		ActorID:   a.contextGetUser(r).ID,
+		IP:        a.clientIP(r),
+		RequestID: a.contextGetRequestID(r),
+		Resource:  resource,
+		Action:    action,
+	}
+}
+
+func (a *applicationDependencies) listAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Resource string
+		ActorID  int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.Resource = a.getSingleQueryParameter(query, "resource", "")
+	input.ActorID = a.getSingleIntegerParameter(query, "actor_id", 0, v)
+	from := a.getOptionalTimeParameter(query, "from", v)
+	to := a.getOptionalTimeParameter(query, "to", v)
+	input.Filters.Page = a.getSingleIntegerParameter(query, "page", 1, v)
+	input.Filters.PageSize = a.getSingleIntegerParameter(query, "page_size", 20, v)
+	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "-created_at")
+	input.Filters.SortSafeList = []string{"id", "created_at", "-id", "-created_at"}
+
+	if from != nil && to != nil {
+		v.Check(from.Before(*to), "to", "later_than", "other", "from")
+	}
+	data.ValidateFilters(v, input.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	events, metadata, err := a.auditModel.GetAll(input.Resource, int64(input.ActorID), from, to, input.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"audit_events": events,
+		"metadata":     metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
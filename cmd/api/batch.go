@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+const maxBatchOperations = 100
+
+type batchOperation struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body"`
+}
+
+type batchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// batchResponseWriter captures a sub-request's response in memory.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *batchResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *batchResponseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+	}
+}
+
+func (rw *batchResponseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	return rw.body.Write(b)
+}
+
+// batchHandler runs each operation against the book and review routes inside
+// a single database transaction. By default the batch is all-or-nothing: the
+// first failing operation rolls everything back and the remaining ones are
+// skipped. With "atomic": false each operation gets its own savepoint, so
+// failures are undone individually and the rest are committed.
+func (a *applicationDependencies) batchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Atomic     *bool            `json:"atomic"`
+		Operations []batchOperation `json:"operations"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	atomic := input.Atomic == nil || *input.Atomic
+
+	v := validator.New()
+	v.Check(len(input.Operations) > 0, "operations", "min_items", "min", 1)
+	v.Check(len(input.Operations) <= maxBatchOperations, "operations", "max_items", "max", maxBatchOperations)
+	for i, op := range input.Operations {
+		key := fmt.Sprintf("operations[%d]", i)
+		v.Check(validator.PermittedValue(op.Method, http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete), key+".method", "one_of", "values", "GET, POST, PATCH, DELETE")
+		v.Check(strings.HasPrefix(op.Path, "/v1/books") || strings.HasPrefix(op.Path, "/v1/reviews"), key+".path", "batch_route")
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// Point a copy of the application at the transaction so the existing
+	// handlers do their writes inside it.
+	batchApp := *a
+	batchApp.bookModel = data.BookModel{DB: tx}
+	batchApp.reviewModel = data.ReviewModel{DB: tx}
+	batchApp.flagModel = data.FlagModel{DB: tx}
+	batchApp.commentModel = data.CommentModel{DB: tx}
+	batchApp.reactionModel = data.ReactionModel{DB: tx}
+	router := batchApp.resourceRouter()
+
+	results := make([]batchResult, len(input.Operations))
+	committed := true
+
+	for i, op := range input.Operations {
+		if !atomic {
+			_, err = tx.ExecContext(ctx, "SAVEPOINT batch_operation")
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+		}
+
+		results[i] = batchApp.runBatchOperation(r, router, op)
+		failed := results[i].Status >= 400
+
+		switch {
+		case failed && atomic:
+			committed = false
+		case failed:
+			_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_operation")
+		default:
+			if !atomic {
+				_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_operation")
+			}
+		}
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if !committed {
+			for j := i + 1; j < len(results); j++ {
+				results[j] = batchResult{Status: http.StatusFailedDependency}
+			}
+			break
+		}
+	}
+
+	if committed {
+		err = tx.Commit()
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	data := envelope{
+		"committed": committed,
+		"results":   results,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) runBatchOperation(r *http.Request, router http.Handler, op batchOperation) batchResult {
+	var body bytes.Buffer
+	if len(op.Body) > 0 {
+		body.Write(op.Body)
+	}
+
+	// The sub-request inherits the caller's context, so it carries the same
+	// authenticated user and request ID.
+	subRequest, err := http.NewRequestWithContext(r.Context(), op.Method, op.Path, &body)
+	if err != nil {
+		return batchResult{Status: http.StatusBadRequest}
+	}
+	subRequest.RemoteAddr = r.RemoteAddr
+	subRequest.Header.Set("Content-Type", "application/json")
+
+	rw := &batchResponseWriter{header: make(http.Header)}
+	router.ServeHTTP(rw, subRequest)
+
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+
+	result := batchResult{Status: rw.status}
+	if json.Valid(rw.body.Bytes()) {
+		result.Body = bytes.TrimSpace(rw.body.Bytes())
+	}
+	return result
+}
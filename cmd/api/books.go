@@ -14,6 +14,7 @@ func (a *applicationDependencies) createBookHandler(w http.ResponseWriter, r *ht
 		Title        string `json:"title"`
 		Author string `json:"author"`
 		Genre    string `json:"genre"`
+		PageCount int   `json:"page_count"`
 	}
 
 	err := a.readJSON(w, r, &input)
@@ -26,6 +27,7 @@ func (a *applicationDependencies) createBookHandler(w http.ResponseWriter, r *ht
 		Title:    input.Title,
 		Author:   input.Author,
 		Genre:    input.Genre,
+		PageCount: input.PageCount,
 	}
 
 	v := validator.New()
@@ -35,7 +37,7 @@ func (a *applicationDependencies) createBookHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	err = a.bookModel.Insert(book)
+	err = a.bookModel.Insert(book, a.newAuditEvent(r, "book", data.AuditActionCreate))
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -94,26 +96,50 @@ func (a *applicationDependencies) updateBookHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	var input struct {
-		Title        *string `json:"title"`
-		Author 		 *string `json:"author"`
-		Genre    	 *string `json:"genre"`
-	}
+	if a.patchMediaType(r) != "" {
+		var patched struct {
+			Title  string `json:"title"`
+			Author string `json:"author"`
+			Genre  string `json:"genre"`
+			PageCount int `json:"page_count"`
+		}
 
-	err = a.readJSON(w, r, &input)
-	if err != nil {
-		a.badRequestResponse(w, r, err)
-		return
-	}
+		err = a.readPatch(w, r, book, []string{"id", "average_rating", "shelf_counts", "version"}, &patched)
+		if err != nil {
+			a.patchErrorResponse(w, r, err)
+			return
+		}
 
-	if input.Title != nil {
-		book.Title = *input.Title
-	}
-	if input.Author != nil {
-		book.Author = *input.Author
-	}
-	if input.Genre != nil {
-		book.Genre = *input.Genre
+		book.Title = patched.Title
+		book.Author = patched.Author
+		book.Genre = patched.Genre
+		book.PageCount = patched.PageCount
+	} else {
+		var input struct {
+			Title        *string `json:"title"`
+			Author 		 *string `json:"author"`
+			Genre    	 *string `json:"genre"`
+			PageCount    *int    `json:"page_count"`
+		}
+
+		err = a.readJSON(w, r, &input)
+		if err != nil {
+			a.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Title != nil {
+			book.Title = *input.Title
+		}
+		if input.Author != nil {
+			book.Author = *input.Author
+		}
+		if input.Genre != nil {
+			book.Genre = *input.Genre
+		}
+		if input.PageCount != nil {
+			book.PageCount = *input.PageCount
+		}
 	}
 
 	v := validator.New()
@@ -123,9 +149,14 @@ func (a *applicationDependencies) updateBookHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	err = a.bookModel.Update(book)
+	err = a.bookModel.Update(book, a.newAuditEvent(r, "book", data.AuditActionUpdate))
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -143,7 +174,7 @@ func (a *applicationDependencies) deleteBookHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	err = a.bookModel.Delete(id)
+	err = a.bookModel.Delete(id, a.newAuditEvent(r, "book", data.AuditActionDelete))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -174,7 +205,9 @@ func (a *applicationDependencies) listBooksHandler(w http.ResponseWriter, r *htt
 	input.Filters.Page = a.getSingleIntegerParameter(query, "page", 1, validator.New())
 	input.Filters.PageSize = a.getSingleIntegerParameter(query, "page_size", 10, validator.New())
 	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "id")
-	input.Filters.SortSafeList = []string{"id", "title", "Author", "-id", "-title", "-author"}
+	input.Filters.SortSafeList = []string{"id", "title", "Author", "-id", "-title", "-author",
+		"want_to_read_count", "currently_reading_count", "read_count",
+		"-want_to_read_count", "-currently_reading_count", "-read_count"}
 
 	v := validator.New()
 	data.ValidateFilters(v, input.Filters)
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// readComment loads the comment named in the URL, which must be visible to
+// the caller. On failure the response has already been sent.
+func (a *applicationDependencies) readComment(w http.ResponseWriter, r *http.Request) (*data.Comment, bool) {
+	review, ok := a.readVisibleReview(w, r)
+	if !ok {
+		return nil, false
+	}
+	commentID, err := a.readCommentIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	comment, err := a.commentModel.Get(review.ID, commentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	if !a.canViewComment(a.contextGetUser(r), comment) {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return comment, true
+}
+
+// canViewComment applies the review visibility rules to a comment.
+func (a *applicationDependencies) canViewComment(user *data.User, comment *data.Comment) bool {
+	switch {
+	case comment.Status == data.ReviewStatusApproved:
+		return true
+	case !user.IsAnonymous() && user.ID == comment.UserID:
+		return true
+	default:
+		return user.Permissions.Include("reviews:moderate")
+	}
+}
+
+func (a *applicationDependencies) createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := a.readVisibleReview(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Content  string `json:"content"`
+		ParentID int64  `json:"parent_id"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := a.contextGetUser(r)
+	comment := &data.Comment{
+		ReviewID: review.ID,
+		ParentID: input.ParentID,
+		UserID:   user.ID,
+		Content:  input.Content,
+		Status:   a.initialReviewStatus(user),
+	}
+
+	v := validator.New()
+
+	if input.ParentID != 0 {
+		parent, err := a.commentModel.Get(review.ID, input.ParentID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("parent_id", "comment_parent")
+		case err != nil:
+			a.serverErrorResponse(w, r, err)
+			return
+		case parent.Deleted || !a.canViewComment(user, parent):
+			v.AddError("parent_id", "comment_parent")
+		default:
+			comment.Depth = parent.Depth + 1
+		}
+	}
+
+	data.ValidateComment(v, comment, a.reviewPolicy.Review())
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.commentModel.Insert(comment, a.newAuditEvent(r, "comment", data.AuditActionCreate))
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{"comment": comment}
+	err = a.writeJSON(w, http.StatusCreated, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) displayCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment, ok := a.readComment(w, r)
+	if !ok {
+		return
+	}
+
+	data := envelope{"comment": comment}
+	err := a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateCommentHandler lets a comment's author edit it. The edit goes back
+// through moderation unless the author is auto-approved.
+func (a *applicationDependencies) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment, ok := a.readComment(w, r)
+	if !ok {
+		return
+	}
+
+	user := a.contextGetUser(r)
+	if comment.UserID != user.ID {
+		a.notPermittedResponse(w, r)
+		return
+	}
+	if comment.Deleted {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Content *string `json:"content"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Content != nil && *input.Content != comment.Content {
+		comment.Content = *input.Content
+		comment.Status = a.initialReviewStatus(user)
+		comment.RejectionReason = ""
+	}
+
+	v := validator.New()
+	data.ValidateComment(v, comment, a.reviewPolicy.Review())
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.commentModel.Update(comment, a.newAuditEvent(r, "comment", data.AuditActionUpdate))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"comment": comment}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteCommentHandler lets the author or a moderator remove a comment.
+func (a *applicationDependencies) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	comment, ok := a.readComment(w, r)
+	if !ok {
+		return
+	}
+
+	user := a.contextGetUser(r)
+	if comment.UserID != user.ID && !user.Permissions.Include("reviews:moderate") {
+		a.notPermittedResponse(w, r)
+		return
+	}
+
+	err := a.commentModel.Delete(comment.ReviewID, comment.ID, a.newAuditEvent(r, "comment", data.AuditActionDelete))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"message": "comment successfully deleted"}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) listCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := a.readVisibleReview(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		ParentID int
+		data.CursorFilters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.ParentID = a.getSingleIntegerParameter(query, "parent_id", 0, v)
+	input.CursorFilters.Cursor = a.getSingleQueryParameter(query, "cursor", "")
+	input.CursorFilters.Limit = a.getSingleIntegerParameter(query, "limit", 20, v)
+
+	data.ValidateCursorFilters(v, input.CursorFilters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, metadata, err := a.commentModel.GetAllForReview(review.ID, int64(input.ParentID), a.contextGetUser(r).ID, input.CursorFilters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"comments": comments,
+		"metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
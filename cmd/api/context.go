@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+)
+
+type contextKey string
+
+const (
+	userContextKey      = contextKey("user")
+	requestIDContextKey = contextKey("request_id")
+)
+
+func (a *applicationDependencies) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+func (a *applicationDependencies) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		return data.AnonymousUser
+	}
+	return user
+}
+
+// isCurrentUser reports whether the caller is the user with the given ID,
+// typically the :id of a /v1/users/:id route.
+func (a *applicationDependencies) isCurrentUser(r *http.Request, userID int64) bool {
+	user := a.contextGetUser(r)
+	return !user.IsAnonymous() && user.ID == userID
+}
+
+func (a *applicationDependencies) contextSetRequestID(r *http.Request, requestID string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, requestID)
+	return r.WithContext(ctx)
+}
+
+func (a *applicationDependencies) contextGetRequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value(requestIDContextKey).(string)
+	return requestID
+}
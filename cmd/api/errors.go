@@ -1,8 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/tchenbz/AWTtest3/internal/jsonpatch"
+	"github.com/tchenbz/AWTtest3/internal/validator"
 )
 
 func (a *applicationDependencies)logError(r *http.Request, err error) {
@@ -12,9 +18,150 @@ func (a *applicationDependencies)logError(r *http.Request, err error) {
 	a.logger.Error(err.Error(), "method", method, "uri", uri)
 }
 
-func (a *applicationDependencies)errorResponseJSON(w http.ResponseWriter, r *http.Request, status int, message any) {
-	errorData := envelope{"error": message}
-	err := a.writeJSON(w, status, errorData, nil)
+// Error codes are part of the API contract: clients branch on them instead of
+// on the English detail text, so existing codes must never change meaning.
+const (
+	errCodeInternal                 = "internal_error"
+	errCodeNotFound                 = "not_found"
+	errCodeMethodNotAllowed         = "method_not_allowed"
+	errCodeBadRequest               = "bad_request"
+	errCodeValidationFailed         = "validation_failed"
+	errCodeRateLimited              = "rate_limited"
+	errCodeInvalidToken             = "invalid_token"
+	errCodeAuthenticationRequired   = "authentication_required"
+	errCodeNotPermitted             = "not_permitted"
+	errCodeUnsupportedMediaType     = "unsupported_media_type"
+	errCodeIdempotencyKeyMismatch   = "idempotency_key_mismatch"
+	errCodeIdempotencyKeyInProgress = "idempotency_key_in_progress"
+	errCodePatchTestFailed          = "patch_test_failed"
+	errCodeAlreadyFlagged           = "already_flagged"
+	errCodeReviewExists             = "review_exists"
+	errCodeBuiltInShelf             = "built_in_shelf"
+	errCodeInactiveAccount          = "inactive_account"
+	errCodeAPIKeyRevoked            = "api_key_revoked"
+	errCodeInvalidCredentials       = "invalid_credentials"
+)
+
+// problem is an RFC 7807 problem details object.
+type problem struct {
+	Type     string         `json:"type"`
+	Title    string         `json:"title"`
+	Status   int            `json:"status"`
+	Detail   string         `json:"detail"`
+	Instance string         `json:"instance,omitempty"`
+	Code     string         `json:"code"`
+	Errors   []problemField `json:"errors,omitempty"`
+}
+
+type problemField struct {
+	Field   string         `json:"field"`
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Params  map[string]any `json:"params,omitempty"`
+}
+
+// requestError is a malformed request, with the message to send back.
+// badRequestResponse sends the message of any requestError it is given.
+type requestError struct {
+	message validator.Message
+}
+
+func newRequestError(code string, params ...any) error {
+	return &requestError{message: validator.NewMessage(code, params...)}
+}
+
+func (e *requestError) Error() string {
+	return e.message.Code
+}
+
+// language picks the response language from the Accept-Language header.
+func (a *applicationDependencies)language(r *http.Request) string {
+	return a.i18n.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+func (a *applicationDependencies)translate(language string, message validator.Message) string {
+	return a.i18n.Translate(language, message.Code, message.Params)
+}
+
+func (a *applicationDependencies)translateErrors(language string, errors map[string][]validator.Message) map[string][]string {
+	translated := make(map[string][]string, len(errors))
+	for field, messages := range errors {
+		for _, message := range messages {
+			translated[field] = append(translated[field], a.translate(language, message))
+		}
+	}
+	return translated
+}
+
+// errorResponseJSON writes an application/problem+json response in the
+// language negotiated from Accept-Language. message is a validator.Message to
+// translate or a map of field errors for validation failures; there is no
+// untranslated detail text. The code is never translated. With
+// -legacy-errors the old {"error": message} envelope is sent instead, with a
+// field's messages joined into one string.
+func (a *applicationDependencies)errorResponseJSON(w http.ResponseWriter, r *http.Request, status int, code string, message any) {
+	language := a.language(r)
+
+	var detail any
+	var fieldErrors []problemField
+	switch message := message.(type) {
+	case validator.Message:
+		detail = a.translate(language, message)
+	case map[string][]validator.Message:
+		legacy := make(map[string]string, len(message))
+		for field, fieldMessages := range message {
+			var texts []string
+			for _, fieldMessage := range fieldMessages {
+				text := a.translate(language, fieldMessage)
+				texts = append(texts, text)
+				fieldErrors = append(fieldErrors, problemField{
+					Field:   field,
+					Code:    fieldMessage.Code,
+					Message: text,
+					Params:  fieldMessage.Params,
+				})
+			}
+			legacy[field] = strings.Join(texts, "; ")
+		}
+		detail = legacy
+		sort.SliceStable(fieldErrors, func(i, j int) bool {
+			return fieldErrors[i].Field < fieldErrors[j].Field
+		})
+	default:
+		detail = fmt.Sprint(message)
+	}
+
+	w.Header().Add("Vary", "Accept-Language")
+	headers := make(http.Header)
+	headers.Set("Content-Language", language)
+
+	if a.config.legacyErrors {
+		errorData := envelope{"error": detail}
+		err := a.writeJSON(w, status, errorData, headers)
+		if err != nil {
+			a.logError(r, err)
+			w.WriteHeader(500)
+		}
+		return
+	}
+
+	p := problem{
+		Type:     "urn:problem-type:" + code,
+		Title:    a.i18n.Translate(language, "title."+code, nil),
+		Status:   status,
+		Instance: a.contextGetRequestID(r),
+		Code:     code,
+		Errors:   fieldErrors,
+	}
+	if fieldErrors != nil {
+		p.Detail = a.i18n.Translate(language, "error."+code, nil)
+	} else {
+		p.Detail = detail.(string)
+	}
+
+	headers.Set("Content-Type", "application/problem+json")
+
+	err := a.writeJSON(w, status, p, headers)
 	if err != nil {
 		a.logError(r, err)
 		w.WriteHeader(500)
@@ -23,29 +170,115 @@ func (a *applicationDependencies)errorResponseJSON(w http.ResponseWriter, r *htt
 
 func (a *applicationDependencies)serverErrorResponse(w http.ResponseWriter, r *http.Request,err error) {
 	a.logError(r, err)
-	message := "the server encountered a problem and could not process your request"
-	a.errorResponseJSON(w, r, http.StatusInternalServerError, message)
+	message := validator.NewMessage("error.internal_error")
+	a.errorResponseJSON(w, r, http.StatusInternalServerError, errCodeInternal, message)
 }
 
 func (a *applicationDependencies)notFoundResponse(w http.ResponseWriter, r *http.Request) {
-	message := "the requested resource could not be found"
-	a.errorResponseJSON(w, r, http.StatusNotFound, message)
+	message := validator.NewMessage("error.not_found")
+	a.errorResponseJSON(w, r, http.StatusNotFound, errCodeNotFound, message)
 }
 
 func (a *applicationDependencies)methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
-	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	a.errorResponseJSON(w, r, http.StatusMethodNotAllowed, message)
+	message := validator.NewMessage("error.method_not_allowed", "method", r.Method)
+	a.errorResponseJSON(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, message)
 }
 
+// badRequestResponse sends the message of a requestError, or a generic one
+// for any other error.
 func (a *applicationDependencies)badRequestResponse(w http.ResponseWriter, r *http.Request, err error)  {
-	a.errorResponseJSON(w, r, http.StatusBadRequest, err.Error())
+	message := validator.NewMessage("error.bad_request")
+	var requestErr *requestError
+	if errors.As(err, &requestErr) {
+		message = requestErr.message
+	}
+	a.errorResponseJSON(w, r, http.StatusBadRequest, errCodeBadRequest, message)
 }
 
-func (a *applicationDependencies)failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	a.errorResponseJSON(w, r, http.StatusUnprocessableEntity, errors)
+func (a *applicationDependencies)failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string][]validator.Message) {
+	a.errorResponseJSON(w, r, http.StatusUnprocessableEntity, errCodeValidationFailed, errors)
 }
 
 func (a *applicationDependencies)rateLimitExceededResponse(w http.ResponseWriter, r *http.Request)  {
-	message := "rate limit exceeded"
-	a.errorResponseJSON(w, r, http.StatusTooManyRequests, message)
+	message := validator.NewMessage("error.rate_limited")
+	a.errorResponseJSON(w, r, http.StatusTooManyRequests, errCodeRateLimited, message)
+}
+
+func (a *applicationDependencies)invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := validator.NewMessage("error.invalid_token")
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, errCodeInvalidToken, message)
+}
+
+func (a *applicationDependencies)authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := validator.NewMessage("error.authentication_required")
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, errCodeAuthenticationRequired, message)
+}
+
+func (a *applicationDependencies)notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := validator.NewMessage("error.not_permitted")
+	a.errorResponseJSON(w, r, http.StatusForbidden, errCodeNotPermitted, message)
+}
+
+func (a *applicationDependencies)unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported []string) {
+	message := validator.NewMessage("error.unsupported_media_type", "types", strings.Join(supported, ", "))
+	a.errorResponseJSON(w, r, http.StatusUnsupportedMediaType, errCodeUnsupportedMediaType, message)
+}
+
+func (a *applicationDependencies)idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := validator.NewMessage("error.idempotency_key_mismatch")
+	a.errorResponseJSON(w, r, http.StatusUnprocessableEntity, errCodeIdempotencyKeyMismatch, message)
+}
+
+func (a *applicationDependencies)idempotencyKeyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := validator.NewMessage("error.idempotency_key_in_progress")
+	a.errorResponseJSON(w, r, http.StatusConflict, errCodeIdempotencyKeyInProgress, message)
+}
+
+// patchErrorResponse reports an error from readPatch, naming the path of
+// the JSON Patch operation that failed.
+func (a *applicationDependencies)patchErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var opErr *jsonpatch.OperationError
+	switch {
+	case errors.As(err, &opErr) && errors.Is(err, jsonpatch.ErrTestFailed):
+		message := validator.NewMessage("error.patch_test_failed", "path", opErr.Path)
+		a.errorResponseJSON(w, r, http.StatusConflict, errCodePatchTestFailed, message)
+	case errors.As(err, &opErr):
+		a.badRequestResponse(w, r, newRequestError("error.patch_invalid", "operation", opErr.Index, "path", opErr.Path))
+	case errors.Is(err, jsonpatch.ErrInvalidPatch):
+		a.badRequestResponse(w, r, newRequestError("error.patch_malformed"))
+	default:
+		a.badRequestResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies)alreadyFlaggedResponse(w http.ResponseWriter, r *http.Request) {
+	message := validator.NewMessage("error.already_flagged")
+	a.errorResponseJSON(w, r, http.StatusConflict, errCodeAlreadyFlagged, message)
+}
+
+func (a *applicationDependencies)reviewExistsResponse(w http.ResponseWriter, r *http.Request, location string) {
+	w.Header().Set("Location", location)
+	message := validator.NewMessage("error.review_exists", "location", location)
+	a.errorResponseJSON(w, r, http.StatusConflict, errCodeReviewExists, message)
+}
+
+func (a *applicationDependencies)builtInShelfResponse(w http.ResponseWriter, r *http.Request) {
+	message := validator.NewMessage("error.built_in_shelf")
+	a.errorResponseJSON(w, r, http.StatusConflict, errCodeBuiltInShelf, message)
+}
+
+func (a *applicationDependencies)inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := validator.NewMessage("error.inactive_account")
+	a.errorResponseJSON(w, r, http.StatusForbidden, errCodeInactiveAccount, message)
+}
+
+func (a *applicationDependencies)apiKeyRevokedResponse(w http.ResponseWriter, r *http.Request) {
+	message := validator.NewMessage("error.api_key_revoked")
+	a.errorResponseJSON(w, r, http.StatusConflict, errCodeAPIKeyRevoked, message)
+}
+
+func (a *applicationDependencies)invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := validator.NewMessage("error.invalid_credentials")
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, errCodeInvalidCredentials, message)
 }
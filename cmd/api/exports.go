@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+const exportFlushEvery = 500
+
+// exportWriter streams rows to the client as CSV or NDJSON, flushing
+// periodically so nothing accumulates in memory.
+type exportWriter struct {
+	rc   *http.ResponseController
+	out  *exportOutput
+	csv  *csv.Writer
+	json *json.Encoder
+	rows int
+}
+
+// exportOutput records whether any of the export has been written to the
+// response, after which its status can no longer change.
+type exportOutput struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (o *exportOutput) Write(p []byte) (int, error) {
+	o.started = true
+	return o.w.Write(p)
+}
+
+func (a *applicationDependencies) newExportWriter(w http.ResponseWriter, format, name string, header []string) (*exportWriter, error) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(30 * time.Minute))
+
+	e := &exportWriter{rc: rc, out: &exportOutput{w: w}}
+
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+		e.csv = csv.NewWriter(e.out)
+		err := e.csv.Write(header)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ndjson"`, name))
+		e.json = json.NewEncoder(e.out)
+	}
+
+	return e, nil
+}
+
+func (e *exportWriter) write(record []string, value any) error {
+	var err error
+	if e.csv != nil {
+		err = e.csv.Write(record)
+	} else {
+		err = e.json.Encode(value)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushEvery == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *exportWriter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return e.rc.Flush()
+}
+
+// exportErrorResponse sends a normal error response if nothing has reached
+// the client yet, dropping any buffered rows. Once streaming has started the
+// status line is gone, so the rows so far are flushed and the response is
+// aborted: the client sees the connection close before the end of the body
+// rather than a complete, truncated file.
+func (a *applicationDependencies) exportErrorResponse(w http.ResponseWriter, r *http.Request, export *exportWriter, err error) {
+	if !export.out.started {
+		w.Header().Del("Content-Disposition")
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	a.logError(r, err)
+	_ = export.flush()
+	panic(http.ErrAbortHandler)
+}
+
+func (a *applicationDependencies) exportBooksHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Format string
+		Title  string
+		Author string
+		data.Filters
+	}
+
+	query := r.URL.Query()
+	input.Format = a.getSingleQueryParameter(query, "format", "csv")
+	input.Title = a.getSingleQueryParameter(query, "title", "")
+	input.Author = a.getSingleQueryParameter(query, "author", "")
+	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "title", "author", "-id", "-title", "-author"}
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(input.Format, "csv", "ndjson"), "format", "one_of", "values", "csv, ndjson")
+	v.Check(validator.PermittedValue(input.Filters.Sort, input.Filters.SortSafeList...), "sort", "invalid_sort")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	header := []string{"id", "title", "author", "genre", "page_count", "average_rating", "version"}
+	export, err := a.newExportWriter(w, input.Format, "books", header)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.bookModel.Export(input.Title, input.Author, input.Filters, func(book *data.Book) error {
+		record := []string{
+			strconv.FormatInt(book.ID, 10),
+			book.Title,
+			book.Author,
+			book.Genre,
+			strconv.Itoa(book.PageCount),
+			strconv.FormatFloat(float64(book.AverageRating), 'f', 2, 32),
+			strconv.FormatInt(int64(book.Version), 10),
+		}
+		return export.write(record, book)
+	})
+	if err == nil {
+		err = export.flush()
+	}
+	if err != nil {
+		a.exportErrorResponse(w, r, export, err)
+	}
+}
+
+func (a *applicationDependencies) exportReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Format  string
+		Content string
+		Author  string
+		Rating  int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.Format = a.getSingleQueryParameter(query, "format", "csv")
+	input.Content = a.getSingleQueryParameter(query, "content", "")
+	input.Author = a.getSingleQueryParameter(query, "author", "")
+	input.Rating = a.getSingleIntegerParameter(query, "rating", 0, v)
+	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "rating", "helpful_count", "-id", "-rating", "-helpful_count"}
+
+	v.Check(validator.PermittedValue(input.Format, "csv", "ndjson"), "format", "one_of", "values", "csv, ndjson")
+	v.Check(validator.PermittedValue(input.Filters.Sort, input.Filters.SortSafeList...), "sort", "invalid_sort")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	header := []string{"id", "book_id", "content", "author", "rating", "helpful_count", "created_at", "version"}
+	export, err := a.newExportWriter(w, input.Format, "reviews", header)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.reviewModel.Export(input.Content, input.Author, input.Rating, input.Filters, func(review *data.Review) error {
+		record := []string{
+			strconv.FormatInt(review.ID, 10),
+			strconv.FormatInt(review.BookID, 10),
+			review.Content,
+			review.Author,
+			strconv.Itoa(review.Rating),
+			strconv.Itoa(review.HelpfulCount),
+			review.CreatedAt.Format(time.RFC3339),
+			strconv.FormatInt(int64(review.Version), 10),
+		}
+		return export.write(record, review)
+	})
+	if err == nil {
+		err = export.flush()
+	}
+	if err != nil {
+		a.exportErrorResponse(w, r, export, err)
+	}
+}
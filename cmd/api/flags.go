@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// createFlagHandler lets a reader report a review. Once the review's flags
+// cross the policy's weighted threshold it is hidden until a moderator
+// approves or rejects it.
+func (a *applicationDependencies) createFlagHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	reviewID, err := a.readReviewIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Reason  string `json:"reason"`
+		Comment string `json:"comment"`
+	}
+
+	err = a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	review, err := a.reviewModel.Get(bookID, reviewID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !a.canViewReview(user, review) {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	flag := &data.Flag{
+		ReviewID: review.ID,
+		UserID:   user.ID,
+		Reason:   input.Reason,
+		Comment:  input.Comment,
+	}
+
+	v := validator.New()
+	data.ValidateFlag(v, flag)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	p := a.reviewPolicy.Review()
+	hidden, err := a.flagModel.Insert(flag, p.Flags.Weights, p.Flags.HideThreshold, a.newAuditEvent(r, "review_flag", data.AuditActionCreate))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateFlag):
+			a.alreadyFlaggedResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"flag":          flag,
+		"review_hidden": hidden,
+	}
+	err = a.writeJSON(w, http.StatusCreated, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// listFlaggedReviewsHandler is the moderators' flag queue, most severe first
+// by default.
+func (a *applicationDependencies) listFlaggedReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.Filters.Page = a.getSingleIntegerParameter(query, "page", 1, v)
+	input.Filters.PageSize = a.getSingleIntegerParameter(query, "page_size", 20, v)
+	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "-severity")
+	input.Filters.SortSafeList = []string{"severity", "flag_count", "last_flagged_at", "-severity", "-flag_count", "-last_flagged_at"}
+
+	data.ValidateFilters(v, input.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	flagged, metadata, err := a.flagModel.GetFlaggedReviews(a.reviewPolicy.Review().Flags.Weights, input.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"flagged_reviews": flagged,
+		"metadata":        metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
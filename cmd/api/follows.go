@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// followUserHandler makes the caller follow the user in the URL. Following
+// someone already followed is harmless.
+func (a *applicationDependencies) followUserHandler(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	v := validator.New()
+	v.Check(followeeID != user.ID, "id", "follow_self")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	follow, err := a.followModel.Insert(user.ID, followeeID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{"follow": follow}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) unfollowUserHandler(w http.ResponseWriter, r *http.Request) {
+	followeeID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.followModel.Delete(a.contextGetUser(r).ID, followeeID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"message": "user successfully unfollowed"}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) listFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	a.listFollows(w, r, "followers", a.followModel.GetFollowers)
+}
+
+func (a *applicationDependencies) listFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	a.listFollows(w, r, "following", a.followModel.GetFollowing)
+}
+
+// listFollows serves both sides of the follow graph, newest follows first by
+// default.
+func (a *applicationDependencies) listFollows(w http.ResponseWriter, r *http.Request, key string, list func(int64, data.Filters) ([]*data.Follow, data.Metadata, error)) {
+	userID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.Filters.Page = a.getSingleIntegerParameter(query, "page", 1, v)
+	input.Filters.PageSize = a.getSingleIntegerParameter(query, "page_size", 20, v)
+	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "-created_at")
+	input.Filters.SortSafeList = []string{"created_at", "-created_at"}
+
+	data.ValidateFilters(v, input.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	follows, metadata, err := list(userID, input.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		key:        follows,
+		"metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// showFeedHandler returns the activity of the users the caller follows,
+// newest first.
+func (a *applicationDependencies) showFeedHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.CursorFilters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.CursorFilters.Cursor = a.getSingleQueryParameter(query, "cursor", "")
+	input.CursorFilters.Limit = a.getSingleIntegerParameter(query, "limit", 20, v)
+
+	data.ValidateCursorFilters(v, input.CursorFilters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	activities, metadata, err := a.activityModel.GetFeed(a.contextGetUser(r).ID, input.CursorFilters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"activities": activities,
+		"metadata":   metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,22 +1,26 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/tchenbz/AWTtest3/internal/jsonpatch"
 	"github.com/tchenbz/AWTtest3/internal/validator"
 )
 
 type envelope map[string]any
 
-func (a *applicationDependencies)writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
+func (a *applicationDependencies)writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
 	jsResponse, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
 		return err
@@ -29,7 +33,9 @@ func (a *applicationDependencies)writeJSON(w http.ResponseWriter, status int, da
 		//w.Header().Set(key, value)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	if headers.Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
 	w.WriteHeader(status)
 	_, err = w.Write(jsResponse)
 	if err != nil {
@@ -54,26 +60,25 @@ func (a *applicationDependencies)readJSON(w http.ResponseWriter, r *http.Request
 
 		switch {
 		case errors.As(err, &syntaxError):
-			return fmt.Errorf("the body contains badly formed JSON (at character %d)", syntaxError.Offset)
+			return newRequestError("error.malformed_json", "position", syntaxError.Offset)
 
 		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("the body contains badly-formed JSON")
+			return newRequestError("error.incomplete_json")
 
 		case errors.As(err, &unmarshalTypeError):
 			if unmarshalTypeError.Field != "" {
-			   return fmt.Errorf("the body contains the incorrect JSON type for field %q", unmarshalTypeError.Field)
+			   return newRequestError("error.json_type", "field", unmarshalTypeError.Field)
 			}
-			return fmt.Errorf("the body contains the incorrect  JSON type (at character %d)", unmarshalTypeError.Offset)
+			return newRequestError("error.json_type_at", "position", unmarshalTypeError.Offset)
 
 		case errors.Is(err, io.EOF):
-			return errors.New("the body must not be empty")
+			return newRequestError("error.empty_body")
 
 		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
+			return newRequestError("error.unknown_field", "field", unknownFieldName(err))
 
 		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("the body must not be larger than %d bytes", maxBytesError.Limit)
+			return newRequestError("error.body_too_large", "limit", maxBytesError.Limit)
 
 	   case errors.As(err, &invalidUnmarshalError):
 			panic(err)
@@ -84,14 +89,25 @@ func (a *applicationDependencies)readJSON(w http.ResponseWriter, r *http.Request
 	}
 	err = dec.Decode(&struct{} {})
 	if !errors.Is(err, io.EOF) {
-		return errors.New("body must only contain a single JSON value")
+		return newRequestError("error.multiple_json_values")
 	}
 	return nil
 }
 
+// unknownFieldName gets the field name out of the error encoding/json returns
+// for an unknown field, which has no type of its own.
+func unknownFieldName(err error) string {
+	return strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+}
+
 func (a *applicationDependencies)readIDParam(r *http.Request)(int64, error) {
     params := httprouter.ParamsFromContext(r.Context())
-    id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+    value := params.ByName("id")
+    if value == "" {
+        // Routes served by the ServeMux in front of the router
+        value = r.PathValue("id")
+    }
+    id, err := strconv.ParseInt(value, 10, 64)
     if err != nil || id < 1 {
         return 0, errors.New("invalid id parameter")
     }
@@ -117,9 +133,170 @@ func (a *applicationDependencies) getSingleIntegerParameter(queryParameters url.
 
 	intValue, err := strconv.Atoi(result)
 	if err != nil {
-		v.AddError(key, "must be an integer value")
+		v.AddError(key, "integer")
 		return defaultValue
 	}
 
 	return intValue
-}
\ No newline at end of file
+}
+func (a *applicationDependencies) getOptionalTimeParameter(queryParameters url.Values, key string, v *validator.Validator) *time.Time {
+	result := queryParameters.Get(key)
+
+	if result == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, result)
+	if err != nil {
+		v.AddError(key, "timestamp")
+		return nil
+	}
+
+	return &t
+}
+
+func (a *applicationDependencies) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// patchMediaType reports which patch format the request body uses, or "" for
+// the plain JSON partial update that readJSON handles.
+func (a *applicationDependencies) patchMediaType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchMediaType, jsonPatchMediaType:
+		return mediaType
+	default:
+		return ""
+	}
+}
+
+// readPatch applies the request body, a JSON Merge Patch or a JSON Patch, to
+// the JSON form of current and decodes the patched document into destination.
+// Members missing from the patched document are left as zero values in
+// destination, which is how a patch clears a field. The keys in readOnly may
+// be used in "test" operations but must not be changed.
+func (a *applicationDependencies) readPatch(w http.ResponseWriter, r *http.Request, current any, readOnly []string, destination any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, 256_000)
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			return newRequestError("error.body_too_large", "limit", maxBytesError.Limit)
+		}
+		return err
+	}
+	if len(bytes.TrimSpace(patch)) == 0 {
+		return newRequestError("error.empty_body")
+	}
+
+	original, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	var patched []byte
+	if a.patchMediaType(r) == mergePatchMediaType {
+		patched, err = jsonpatch.MergePatch(original, patch)
+	} else {
+		patched, err = jsonpatch.Apply(original, patch)
+	}
+	if err != nil {
+		return err
+	}
+
+	var before, after map[string]json.RawMessage
+	if err = json.Unmarshal(original, &before); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(patched, &after); err != nil {
+		return newRequestError("error.patch_not_object")
+	}
+	for _, key := range readOnly {
+		if !bytes.Equal(before[key], after[key]) {
+			return newRequestError("error.read_only_field", "field", key)
+		}
+		delete(after, key)
+	}
+
+	editable, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(editable))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(destination)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			return newRequestError("error.json_type", "field", unmarshalTypeError.Field)
+		case strings.HasPrefix(err.Error(), "json: unknown field"):
+			return newRequestError("error.unknown_field", "field", unknownFieldName(err))
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *applicationDependencies) readReviewIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("review_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid review_id parameter")
+	}
+
+	return id, nil
+}
+
+func (a *applicationDependencies) readCommentIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("comment_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid comment_id parameter")
+	}
+
+	return id, nil
+}
+
+func (a *applicationDependencies) readBookIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("book_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid book_id parameter")
+	}
+
+	return id, nil
+}
+
+func (a *applicationDependencies) readSessionIDParam(r *http.Request) (int64, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.ParseInt(params.ByName("session_id"), 10, 64)
+	if err != nil || id < 1 {
+		return 0, errors.New("invalid session_id parameter")
+	}
+
+	return id, nil
+}
+
+func (a *applicationDependencies) readYearParam(r *http.Request) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	year, err := strconv.Atoi(params.ByName("year"))
+	if err != nil || year < 1 || year > 9999 {
+		return 0, errors.New("invalid year parameter")
+	}
+
+	return year, nil
+}
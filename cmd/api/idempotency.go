@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// idempotencyRecorder passes the response through to the client while keeping
+// a copy to store against the Idempotency-Key.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent honours the Idempotency-Key header. The first request with a key
+// runs normally and its response is stored for config.idempotency.ttl; a retry
+// with the same key and body gets the stored response replayed, and a retry
+// with a different body is rejected. Keys are scoped to the calling user.
+func (a *applicationDependencies) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			a.badRequestResponse(w, r, newRequestError("error.idempotency_key_too_long", "max", 255))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 256_000))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				a.badRequestResponse(w, r, newRequestError("error.body_too_large", "limit", maxBytesError.Limit))
+				return
+			}
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s\n", r.Method, r.URL.RequestURI())
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		userID := a.contextGetUser(r).ID
+
+		record, err := a.idempotencyModel.Claim(userID, key, fingerprint, a.config.idempotency.ttl)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if record != nil {
+			switch {
+			case record.Fingerprint != fingerprint:
+				a.idempotencyKeyMismatchResponse(w, r)
+			case record.Status == 0:
+				a.idempotencyKeyInProgressResponse(w, r)
+			default:
+				for name, values := range record.Header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(record.Status)
+				w.Write(record.Body)
+			}
+			return
+		}
+
+		// Unless the response is stored, free the key so the client can try
+		// again. This also runs if next panics, which would otherwise leave
+		// the key claimed until it expires.
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			err := a.idempotencyModel.Release(userID, key)
+			if err != nil {
+				a.logError(r, err)
+			}
+		}()
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// Server errors are not worth replaying.
+		if rec.status == 0 || rec.status >= 500 {
+			return
+		}
+
+		header := rec.Header().Clone()
+		header.Del("X-Request-ID")
+		err = a.idempotencyModel.Complete(userID, key, rec.status, header, rec.body.Bytes())
+		if err != nil {
+			a.logError(r, err)
+			return
+		}
+		completed = true
+	})
+}
+
+// purgeExpiredIdempotencyKeys deletes stored responses once their TTL has
+// passed. It runs for the life of the process.
+func (a *applicationDependencies) purgeExpiredIdempotencyKeys() {
+	for {
+		time.Sleep(time.Hour)
+		_, err := a.idempotencyModel.DeleteExpired()
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+const (
+	maxImportBytes        = 64 << 20
+	maxImportErrorsListed = 1000
+)
+
+// importFields are the book fields an import can set, matching the columns
+// of a books export so an export can be imported again.
+var importFields = []string{"title", "author", "genre", "page_count"}
+
+type importRowError struct {
+	Row    int                 `json:"row"`
+	Errors map[string][]string `json:"errors"`
+}
+
+type importReport struct {
+	DryRun    bool             `json:"dry_run"`
+	TotalRows int              `json:"total_rows"`
+	Valid     int              `json:"valid"`
+	Imported  int              `json:"imported"`
+	Failed    int              `json:"failed"`
+	Errors    []importRowError `json:"errors"`
+}
+
+func (rep *importReport) addError(row int, errs map[string][]string) {
+	rep.Failed++
+	if len(rep.Errors) < maxImportErrorsListed {
+		rep.Errors = append(rep.Errors, importRowError{Row: row, Errors: errs})
+	}
+}
+
+// importRowReader yields one source row at a time as a field -> value map,
+// already translated through the column mapping.
+type importRowReader func() (map[string]string, error)
+
+func (a *applicationDependencies) importBooksHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	query := r.URL.Query()
+	dryRun := a.getSingleQueryParameter(query, "dry_run", "false") == "true"
+	mapping := parseImportMapping(a.getSingleQueryParameter(query, "mapping", ""), v)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Large catalogs take longer than the server-wide read and write
+	// timeouts allow.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Now().Add(5 * time.Minute))
+	_ = rc.SetWriteDeadline(time.Now().Add(5 * time.Minute))
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var readRow importRowReader
+	var err error
+	switch mediaType {
+	case "text/csv":
+		readRow, err = newCSVRowReader(body, mapping)
+	case "application/x-ndjson", "application/ndjson":
+		readRow = newNDJSONRowReader(body, mapping)
+	default:
+		a.unsupportedMediaTypeResponse(w, r, []string{"text/csv", "application/x-ndjson"})
+		return
+	}
+	if err != nil {
+		a.importErrorResponse(w, r, err)
+		return
+	}
+
+	report := &importReport{DryRun: dryRun, Errors: []importRowError{}}
+	language := a.language(r)
+
+	// next validates rows as they are pulled, recording bad ones in the
+	// report and handing only valid books on to the importer.
+	next := func() (*data.Book, error) {
+		for {
+			fields, err := readRow()
+			if err == io.EOF {
+				return nil, nil
+			}
+
+			// A malformed row is still a row, but a failed read of the body
+			// isn't one.
+			var rowErr *importParseError
+			if errors.As(err, &rowErr) {
+				report.TotalRows++
+				report.addError(report.TotalRows, map[string][]string{"row": {a.translate(language, rowErr.message)}})
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			report.TotalRows++
+
+			book := &data.Book{
+				Title:  fields["title"],
+				Author: fields["author"],
+				Genre:  fields["genre"],
+			}
+
+			v := validator.New()
+			if value := fields["page_count"]; value != "" {
+				pageCount, err := strconv.Atoi(value)
+				v.Check(err == nil, "page_count", "integer")
+				book.PageCount = pageCount
+			}
+			data.ValidateBook(v, book)
+			if !v.IsEmpty() {
+				report.addError(report.TotalRows, a.translateErrors(language, v.Errors))
+				continue
+			}
+
+			report.Valid++
+			return book, nil
+		}
+	}
+
+	if dryRun {
+		for {
+			book, err := next()
+			if err != nil {
+				a.importErrorResponse(w, r, err)
+				return
+			}
+			if book == nil {
+				break
+			}
+		}
+	} else {
+		report.Imported, err = a.bookModel.Import(a.newAuditEvent(r, "book", data.AuditActionImport), next)
+		if err != nil {
+			a.importErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"report": report}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// importErrorResponse reports stream-level failures such as an oversized
+// body or a bad CSV header as client errors and everything else as a server
+// error.
+func (a *applicationDependencies) importErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var requestErr *requestError
+	if errors.As(err, &requestErr) {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		a.badRequestResponse(w, r, newRequestError("error.body_too_large", "limit", maxBytesError.Limit))
+		return
+	}
+	if errors.Is(err, bufio.ErrTooLong) {
+		a.badRequestResponse(w, r, newRequestError("error.row_too_long"))
+		return
+	}
+	a.serverErrorResponse(w, r, err)
+}
+
+// importParseError marks a single malformed row; the import carries on with
+// the next one.
+type importParseError struct {
+	message validator.Message
+}
+
+func (e *importParseError) Error() string {
+	return e.message.Code
+}
+
+// parseImportMapping reads "title:Book Title,author:Writer" into a map from
+// book field to source column or key. Unmapped fields use their own name.
+func parseImportMapping(value string, v *validator.Validator) map[string]string {
+	mapping := make(map[string]string, len(importFields))
+	for _, field := range importFields {
+		mapping[field] = field
+	}
+	if value == "" {
+		return mapping
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		field, source, ok := strings.Cut(pair, ":")
+		field = strings.TrimSpace(field)
+		source = strings.TrimSpace(source)
+		if !ok || source == "" {
+			v.AddError("mapping", "import_mapping")
+			continue
+		}
+		if !validator.PermittedValue(field, importFields...) {
+			v.AddError("mapping", "unknown_field", "name", field)
+			continue
+		}
+		mapping[field] = source
+	}
+
+	return mapping
+}
+
+func newCSVRowReader(body io.Reader, mapping map[string]string) (importRowReader, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, newRequestError("error.csv_no_header")
+		}
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			return nil, newRequestError("error.csv_malformed_header", "reason", parseError.Err.Error())
+		}
+		return nil, err
+	}
+
+	columns := make(map[string]int, len(mapping))
+	for field, source := range mapping {
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), source) {
+				columns[field] = i
+				break
+			}
+		}
+	}
+	for _, field := range []string{"title", "author"} {
+		if _, ok := columns[field]; !ok {
+			return nil, newRequestError("error.csv_missing_column", "column", mapping[field])
+		}
+	}
+
+	return func() (map[string]string, error) {
+		record, err := reader.Read()
+		if err != nil {
+			var parseError *csv.ParseError
+			if errors.As(err, &parseError) {
+				return nil, &importParseError{message: validator.NewMessage("malformed_row", "reason", parseError.Err.Error())}
+			}
+			return nil, err
+		}
+
+		fields := make(map[string]string, len(columns))
+		for field, i := range columns {
+			if i < len(record) {
+				fields[field] = strings.TrimSpace(record[i])
+			}
+		}
+		return fields, nil
+	}, nil
+}
+
+func newNDJSONRowReader(body io.Reader, mapping map[string]string) importRowReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	return func() (map[string]string, error) {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+
+			var object map[string]any
+			err := json.Unmarshal([]byte(line), &object)
+			if err != nil {
+				return nil, &importParseError{message: validator.NewMessage("malformed_json_row")}
+			}
+
+			fields := make(map[string]string, len(mapping))
+			for field, source := range mapping {
+				value, ok := object[source]
+				if !ok || value == nil {
+					continue
+				}
+				// Numbers are taken as written, as exports write page_count.
+				switch value := value.(type) {
+				case string:
+					fields[field] = strings.TrimSpace(value)
+				case float64:
+					fields[field] = strconv.FormatFloat(value, 'f', -1, 64)
+				default:
+					return nil, &importParseError{message: validator.NewMessage("string_value", "key", source)}
+				}
+			}
+			return fields, nil
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+}
@@ -4,16 +4,24 @@ import (
 	"context"
 	"database/sql"
 	"flag"
+	"fmt"
 	"log/slog"
 	"os"
 	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/i18n"
+	"github.com/tchenbz/AWTtest3/internal/mailer"
+	"github.com/tchenbz/AWTtest3/internal/policy"
 )
 
 const appVersion = "1.0.0"
 
+// minJWTSecretLength is the shortest -jwt-secret the server will start with:
+// 256 bits, the size of an HS256 key.
+const minJWTSecretLength = 32
+
 type serverConfig struct {
 	port        int
 	environment string
@@ -25,14 +33,50 @@ type serverConfig struct {
         burst int                        
         enabled bool                     
     }
+	jwt struct {
+		secret string
+	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+		sink     string
+		sinkDir  string
+	}
+	legacyErrors bool
+	reviewPolicy string
 
 }
 
 type applicationDependencies struct {
 	config        serverConfig
 	logger        *slog.Logger
+	i18n          *i18n.Bundle
+	reviewPolicy  *policy.Engine
+	db            *sql.DB
+	mailer        *mailer.Mailer
+	activationLimiter *mailLimiter
+	passwordResetLimiter *mailLimiter
 	bookModel  	data.BookModel
 	reviewModel   data.ReviewModel
+	auditModel    data.AuditModel
+	idempotencyModel data.IdempotencyModel
+	flagModel     data.FlagModel
+	commentModel  data.CommentModel
+	reactionModel data.ReactionModel
+	shelfModel    data.ShelfModel
+	readingSessionModel data.ReadingSessionModel
+	followModel   data.FollowModel
+	activityModel data.ActivityModel
+	notificationModel data.NotificationModel
+	userModel     data.UserModel
+	tokenModel    data.TokenModel
+	apiKeyModel   data.APIKeyModel
 }
 
 func main() {
@@ -44,10 +88,46 @@ func main() {
 	flag.Float64Var(&settings.limiter.rps, "limiter-rps", 2, "Rate Limiter maximum requests per second")
 	flag.IntVar(&settings.limiter.burst, "limiter-burst", 5, "Rate Limiter maximum burst")
 	flag.BoolVar(&settings.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	flag.StringVar(&settings.jwt.secret, "jwt-secret", os.Getenv("TEST3_JWT_SECRET"), "JWT signing secret")
+	flag.DurationVar(&settings.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "How long Idempotency-Key responses are kept")
+	flag.StringVar(&settings.smtp.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&settings.smtp.port, "smtp-port", 25, "SMTP port")
+	flag.StringVar(&settings.smtp.username, "smtp-username", os.Getenv("TEST3_SMTP_USERNAME"), "SMTP username")
+	flag.StringVar(&settings.smtp.password, "smtp-password", os.Getenv("TEST3_SMTP_PASSWORD"), "SMTP password")
+	flag.StringVar(&settings.smtp.sender, "smtp-sender", "Books API <no-reply@books.example.com>", "SMTP sender")
+	flag.StringVar(&settings.smtp.sink, "smtp-sink", "smtp", "Where emails go (smtp|file|memory)")
+	flag.StringVar(&settings.smtp.sinkDir, "smtp-sink-dir", "tmp/mail", "Directory for -smtp-sink=file")
+	flag.BoolVar(&settings.legacyErrors, "legacy-errors", false, "Send errors in the legacy {\"error\": ...} envelope instead of application/problem+json")
+	flag.StringVar(&settings.reviewPolicy, "review-policy", "", "Path to the review content policy JSON file (reloaded on SIGHUP)")
 	flag.Parse()
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
+	// Anyone who knows the secret can sign tokens for any user, so refuse
+	// to start with one that's missing or easy to guess.
+	if len(settings.jwt.secret) < minJWTSecretLength {
+		logger.Error(fmt.Sprintf("-jwt-secret must be at least %d bytes", minJWTSecretLength))
+		os.Exit(1)
+	}
+
+	bundle, err := i18n.New()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	reviewPolicy, err := policy.NewEngine(settings.reviewPolicy)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	sender, err := newMailSender(settings)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
 	db, err := openDB(settings)
 	if err != nil {
 		logger.Error(err.Error())
@@ -59,8 +139,27 @@ func main() {
 	appInstance := &applicationDependencies{
 		config:    settings,
 		logger:    logger,
+		i18n:      bundle,
+		reviewPolicy: reviewPolicy,
+		db:        db,
+		mailer:    mailer.New(sender, settings.smtp.sender, logger),
+		activationLimiter: newMailLimiter(activationResendInterval),
+		passwordResetLimiter: newMailLimiter(passwordResetInterval),
 		bookModel: data.BookModel{DB: db},
 		reviewModel: data.ReviewModel{DB: db},
+		auditModel: data.AuditModel{DB: db},
+		idempotencyModel: data.IdempotencyModel{DB: db},
+		flagModel: data.FlagModel{DB: db},
+		commentModel: data.CommentModel{DB: db},
+		reactionModel: data.ReactionModel{DB: db},
+		shelfModel: data.ShelfModel{DB: db},
+		readingSessionModel: data.ReadingSessionModel{DB: db},
+		followModel: data.FollowModel{DB: db},
+		activityModel: data.ActivityModel{DB: db},
+		notificationModel: data.NotificationModel{DB: db},
+		userModel: data.UserModel{DB: db},
+		tokenModel: data.TokenModel{DB: db},
+		apiKeyModel: data.APIKeyModel{DB: db},
 	}
 
     err = appInstance.serve()
@@ -71,6 +170,24 @@ func main() {
 
 }
 
+func newMailSender(settings serverConfig) (mailer.Sender, error) {
+	switch settings.smtp.sink {
+	case "smtp":
+		return mailer.SMTPSender{
+			Host:     settings.smtp.host,
+			Port:     settings.smtp.port,
+			Username: settings.smtp.username,
+			Password: settings.smtp.password,
+		}, nil
+	case "file":
+		return mailer.FileSender{Dir: settings.smtp.sinkDir}, nil
+	case "memory":
+		return &mailer.MemorySender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown -smtp-sink %q", settings.smtp.sink)
+	}
+}
+
 func openDB(settings serverConfig) (*sql.DB, error) {
 	db, err := sql.Open("postgres", settings.db.dsn)
 	if err != nil {
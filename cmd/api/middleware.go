@@ -1,21 +1,31 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
     "github.com/dgrijalva/jwt-go"
+	"github.com/tchenbz/AWTtest3/internal/data"
 )
 
 func (a *applicationDependencies)recoverPanic(next http.Handler)http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func ()  {
 			err := recover();
+			// ErrAbortHandler deliberately cuts off a response that has
+			// already started; net/http closes the connection quietly.
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
 			if err != nil {
 				w.Header().Set("Connection", "close")
 				a.serverErrorResponse(w, r, fmt.Errorf("%s", err))
@@ -75,25 +85,183 @@ func (a *applicationDependencies) rateLimit(next http.Handler) http.Handler {
 
 }
 
-func CheckAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        tokenString := r.Header.Get("Authorization")
-        if tokenString == "" {
-            http.Error(w, "Unauthorized", http.StatusUnauthorized)
-            return
-        }
+func (a *applicationDependencies) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			b := make([]byte, 16)
+			_, err := rand.Read(b)
+			if err != nil {
+				a.serverErrorResponse(w, r, err)
+				return
+			}
+			requestID = hex.EncodeToString(b)
+		}
 
-        tokenString = strings.TrimPrefix(tokenString, "Bearer ")
-        token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-            return []byte("your-secret-key"), nil
-        })
+		w.Header().Set("X-Request-ID", requestID)
+		r = a.contextSetRequestID(r, requestID)
+		next.ServeHTTP(w, r)
+	})
+}
 
-        if err != nil || !token.Valid {
-            http.Error(w, "Unauthorized", http.StatusUnauthorized)
-            return
-        }
+func (a *applicationDependencies) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
 
-        // Proceed to next handler if token is valid
-        next.ServeHTTP(w, r)
-    })
-}
\ No newline at end of file
+		authorizationHeader := r.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			r = a.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token, err := jwt.Parse(headerParts[1], func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+			}
+			return []byte(a.config.jwt.secret), nil
+		})
+		if err != nil || !token.Valid {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		subject, _ := claims["sub"].(string)
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil || userID < 1 {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// A password reset revokes every token issued before it. Tokens
+		// without an iat claim count as issued at the epoch.
+		issuedAt, _ := claims["iat"].(float64)
+		revoked, activated, err := a.userModel.SessionStatus(userID, time.Unix(int64(issuedAt), 0))
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		if revoked {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user := &data.User{ID: userID, Activated: activated}
+		permissions, _ := claims["permissions"].([]interface{})
+		for _, permission := range permissions {
+			if code, ok := permission.(string); ok {
+				user.Permissions = append(user.Permissions, code)
+			}
+		}
+
+		r = a.contextSetUser(r, user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateAPIKey authenticates requests carrying an X-API-Key header as
+// the key's owner, with the key's scopes as permissions. It runs after
+// authenticate, and a request can't use both a key and a bearer token. Keys
+// without the write scope can only make safe requests.
+func (a *applicationDependencies) authenticateAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "X-API-Key")
+
+		plaintext := r.Header.Get("X-API-Key")
+		if plaintext == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !a.contextGetUser(r).IsAnonymous() {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := a.apiKeyModel.Authenticate(plaintext)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.invalidAuthenticationTokenResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !user.Permissions.Include(data.APIKeyScopeWrite) {
+				a.notPermittedResponse(w, r)
+				return
+			}
+		}
+
+		r = a.contextSetUser(r, user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *applicationDependencies) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if user.IsAnonymous() {
+			a.authenticationRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireActivatedUser lets through authenticated users who have confirmed
+// their email address.
+func (a *applicationDependencies) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if !user.Activated {
+			a.inactiveAccountResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return a.requireAuthenticatedUser(fn)
+}
+
+// requireSessionUser lets through users signed in with a bearer token. API
+// keys can't manage API keys, so a leaked key can't be used to mint more.
+func (a *applicationDependencies) requireSessionUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if user.APIKeyID != 0 {
+			a.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return a.requireAuthenticatedUser(fn)
+}
+
+func (a *applicationDependencies) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if !user.Permissions.Include(code) {
+			a.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return a.requireAuthenticatedUser(fn)
+}
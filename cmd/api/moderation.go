@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// initialReviewStatus is the status a new or edited review by user starts in,
+// according to the review policy's auto-approve setting. Moderators and users
+// with the reviews:trusted permission count as trusted.
+func (a *applicationDependencies) initialReviewStatus(user *data.User) string {
+	trusted := user.Permissions.Include("reviews:moderate") || user.Permissions.Include("reviews:trusted")
+	if a.reviewPolicy.Review().Approves(trusted) {
+		return data.ReviewStatusApproved
+	}
+	return data.ReviewStatusPending
+}
+
+// canViewReview hides unapproved and flag-hidden reviews from everyone except
+// their author and moderators. Drafts are only visible to their author.
+func (a *applicationDependencies) canViewReview(user *data.User, review *data.Review) bool {
+	switch {
+	case review.Status == data.ReviewStatusApproved && !review.Hidden && !review.Draft:
+		return true
+	case !user.IsAnonymous() && user.ID == review.UserID:
+		return true
+	case review.Draft:
+		return false
+	default:
+		return user.Permissions.Include("reviews:moderate")
+	}
+}
+
+// canEditReview reports whether user may change or delete review: only its
+// author and moderators can.
+func (a *applicationDependencies) canEditReview(user *data.User, review *data.Review) bool {
+	if !user.IsAnonymous() && user.ID == review.UserID {
+		return true
+	}
+	return user.Permissions.Include("reviews:moderate")
+}
+
+func (a *applicationDependencies) listModerationReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		BookID int
+		data.Filters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.Status = a.getSingleQueryParameter(query, "status", data.ReviewStatusPending)
+	input.BookID = a.getSingleIntegerParameter(query, "book_id", 0, v)
+	input.Filters.Page = a.getSingleIntegerParameter(query, "page", 1, v)
+	input.Filters.PageSize = a.getSingleIntegerParameter(query, "page_size", 20, v)
+	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "created_at", "rating", "-id", "-created_at", "-rating"}
+
+	v.Check(validator.PermittedValue(input.Status, data.ReviewStatusPending, data.ReviewStatusApproved, data.ReviewStatusRejected), "status", "one_of", "values", "pending, approved, rejected")
+	data.ValidateFilters(v, input.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := a.reviewModel.GetAllByStatus(input.Status, int64(input.BookID), input.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"reviews":  reviews,
+		"metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) approveReviewHandler(w http.ResponseWriter, r *http.Request) {
+	a.moderateReview(w, r, data.ReviewStatusApproved, "")
+}
+
+func (a *applicationDependencies) rejectReviewHandler(w http.ResponseWriter, r *http.Request) {
+	reason, ok := a.readRejectionReason(w, r)
+	if !ok {
+		return
+	}
+	a.moderateReview(w, r, data.ReviewStatusRejected, reason)
+}
+
+// readRejectionReason reads the required {"reason": ...} body of a reject
+// request. On failure the response has already been sent.
+func (a *applicationDependencies) readRejectionReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var input struct {
+		Reason string `json:"reason" validate:"required,max_len=500"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return "", false
+	}
+
+	v := validator.New()
+	v.Struct(input)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return "", false
+	}
+
+	return input.Reason, true
+}
+
+func (a *applicationDependencies) moderateReview(w http.ResponseWriter, r *http.Request, status, reason string) {
+	reviewID, err := a.readReviewIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	action := data.AuditActionApprove
+	if status == data.ReviewStatusRejected {
+		action = data.AuditActionReject
+	}
+
+	moderator := a.contextGetUser(r)
+	review, err := a.reviewModel.Moderate(reviewID, status, reason, moderator.ID, a.newAuditEvent(r, "review", action))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"review": review}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) listModerationCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Status string
+		data.CursorFilters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.Status = a.getSingleQueryParameter(query, "status", data.ReviewStatusPending)
+	input.CursorFilters.Cursor = a.getSingleQueryParameter(query, "cursor", "")
+	input.CursorFilters.Limit = a.getSingleIntegerParameter(query, "limit", 20, v)
+
+	v.Check(validator.PermittedValue(input.Status, data.ReviewStatusPending, data.ReviewStatusApproved, data.ReviewStatusRejected), "status", "one_of", "values", "pending, approved, rejected")
+	data.ValidateCursorFilters(v, input.CursorFilters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, metadata, err := a.commentModel.GetAllByStatus(input.Status, input.CursorFilters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"comments": comments,
+		"metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) approveCommentHandler(w http.ResponseWriter, r *http.Request) {
+	a.moderateComment(w, r, data.ReviewStatusApproved, "")
+}
+
+func (a *applicationDependencies) rejectCommentHandler(w http.ResponseWriter, r *http.Request) {
+	reason, ok := a.readRejectionReason(w, r)
+	if !ok {
+		return
+	}
+	a.moderateComment(w, r, data.ReviewStatusRejected, reason)
+}
+
+func (a *applicationDependencies) moderateComment(w http.ResponseWriter, r *http.Request, status, reason string) {
+	commentID, err := a.readCommentIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	action := data.AuditActionApprove
+	if status == data.ReviewStatusRejected {
+		action = data.AuditActionReject
+	}
+
+	moderator := a.contextGetUser(r)
+	comment, err := a.commentModel.Moderate(commentID, status, reason, moderator.ID, a.newAuditEvent(r, "comment", action))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"comment": comment}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// localizeNotifications fills in each notification's message in the
+// negotiated language, e.g. "12 people reacted to your review".
+func (a *applicationDependencies) localizeNotifications(r *http.Request, notifications ...*data.Notification) http.Header {
+	language := a.language(r)
+	for _, notification := range notifications {
+		form := "other"
+		if notification.ActorCount == 1 {
+			form = "one"
+		}
+		notification.Message = a.i18n.Translate(language, "notification."+notification.Type+"."+form, map[string]any{"count": notification.ActorCount})
+	}
+
+	headers := make(http.Header)
+	headers.Set("Content-Language", language)
+	headers.Set("Vary", "Accept-Language")
+	return headers
+}
+
+// listNotificationsHandler lists the caller's notifications with their
+// unread count. ?unread=true leaves out the ones already read.
+func (a *applicationDependencies) listNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Unread bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.Unread = a.getSingleQueryParameter(query, "unread", "false") == "true"
+	input.Filters.Page = a.getSingleIntegerParameter(query, "page", 1, v)
+	input.Filters.PageSize = a.getSingleIntegerParameter(query, "page_size", 20, v)
+	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "-updated_at")
+	input.Filters.SortSafeList = []string{"updated_at", "created_at", "-updated_at", "-created_at"}
+
+	data.ValidateFilters(v, input.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	notifications, metadata, unread, err := a.notificationModel.GetAll(a.contextGetUser(r).ID, input.Unread, input.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	headers := a.localizeNotifications(r, notifications...)
+
+	data := envelope{
+		"notifications": notifications,
+		"unread_count":  unread,
+		"metadata":      metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) markNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	notification, err := a.notificationModel.MarkRead(a.contextGetUser(r).ID, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	headers := a.localizeNotifications(r, notification)
+
+	data := envelope{"notification": notification}
+	err = a.writeJSON(w, http.StatusOK, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) markAllNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	marked, err := a.notificationModel.MarkAllRead(a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{"marked_read": marked, "unread_count": 0}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) showNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	preferences, err := a.notificationModel.GetPreferences(a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{"preferences": preferences}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateNotificationPreferencesHandler takes a map of notification type to
+// on or off, e.g. {"reaction": false}. Types left out keep their setting.
+func (a *applicationDependencies) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	var input map[string]bool
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	for notificationType := range input {
+		if !validator.PermittedValue(notificationType, data.NotificationTypes...) {
+			v.AddError("preferences", "unknown_field", "name", notificationType)
+		}
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	err = a.notificationModel.SetPreferences(user.ID, input)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	preferences, err := a.notificationModel.GetPreferences(user.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{"preferences": preferences}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadReviewPolicyOnSignal re-reads the review policy file whenever the
+// process receives SIGHUP. A bad file is logged and the old policy is kept.
+func (a *applicationDependencies) reloadReviewPolicyOnSignal() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		err := a.reviewPolicy.Reload()
+		if err != nil {
+			a.logger.Error("review policy not reloaded", "error", err.Error())
+			continue
+		}
+		a.logger.Info("review policy reloaded", "path", a.config.reviewPolicy)
+	}
+}
+
+func (a *applicationDependencies) showReviewPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	err := a.writeJSON(w, http.StatusOK, envelope{"review_policy": a.reviewPolicy.Review()}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// reloadReviewPolicyHandler does the same as SIGHUP for operators who cannot
+// signal the process. An invalid file is reported and the old policy is kept.
+func (a *applicationDependencies) reloadReviewPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	err := a.reviewPolicy.Reload()
+	if err != nil {
+		a.badRequestResponse(w, r, newRequestError("error.policy_invalid", "reason", err.Error()))
+		return
+	}
+	a.logger.Info("review policy reloaded", "path", a.config.reviewPolicy)
+
+	a.showReviewPolicyHandler(w, r)
+}
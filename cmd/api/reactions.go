@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+func (a *applicationDependencies) addReactionHandler(w http.ResponseWriter, r *http.Request) {
+	a.setReaction(w, r, true)
+}
+
+func (a *applicationDependencies) removeReactionHandler(w http.ResponseWriter, r *http.Request) {
+	a.setReaction(w, r, false)
+}
+
+// setReaction handles PUT and DELETE on a reaction. Both are idempotent and
+// respond with the review's updated counts.
+func (a *applicationDependencies) setReaction(w http.ResponseWriter, r *http.Request, add bool) {
+	review, ok := a.readVisibleReview(w, r)
+	if !ok {
+		return
+	}
+
+	reaction := httprouter.ParamsFromContext(r.Context()).ByName("reaction")
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(reaction, data.ReactionTypes...), "reaction", "one_of", "values", "like, funny, insightful, sad")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	counts, err := a.reactionModel.Set(review.ID, a.contextGetUser(r).ID, reaction, add)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"reactions": counts}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) addHelpfulVoteHandler(w http.ResponseWriter, r *http.Request) {
+	a.setHelpfulVote(w, r, true)
+}
+
+func (a *applicationDependencies) removeHelpfulVoteHandler(w http.ResponseWriter, r *http.Request) {
+	a.setHelpfulVote(w, r, false)
+}
+
+// setHelpfulVote handles PUT and DELETE on the caller's helpful vote. Both
+// are idempotent and respond with the review's updated helpful count.
+func (a *applicationDependencies) setHelpfulVote(w http.ResponseWriter, r *http.Request, add bool) {
+	review, ok := a.readVisibleReview(w, r)
+	if !ok {
+		return
+	}
+
+	count, err := a.reactionModel.SetHelpful(review.ID, a.contextGetUser(r).ID, add)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"helpful_count": count}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
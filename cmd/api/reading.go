@@ -0,0 +1,376 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// readOwnUserID returns the :id of a /v1/users/:id route whose resources
+// only that user may see. On failure the response has already been sent.
+func (a *applicationDependencies) readOwnUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return 0, false
+	}
+	if !a.isCurrentUser(r, userID) {
+		a.notPermittedResponse(w, r)
+		return 0, false
+	}
+
+	return userID, true
+}
+
+// readSession loads the caller's reading session named in the URL, and the
+// book it is for. On failure the response has already been sent.
+func (a *applicationDependencies) readSession(w http.ResponseWriter, r *http.Request) (*data.ReadingSession, *data.Book, bool) {
+	userID, ok := a.readOwnUserID(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	sessionID, err := a.readSessionIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, nil, false
+	}
+
+	session, err := a.readingSessionModel.Get(userID, sessionID)
+	if err == nil {
+		var book *data.Book
+		book, err = a.bookModel.Get(session.BookID)
+		if err == nil {
+			return session, book, true
+		}
+	}
+
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		a.notFoundResponse(w, r)
+	default:
+		a.serverErrorResponse(w, r, err)
+	}
+	return nil, nil, false
+}
+
+// readingProgressInput is the progress a client may send when starting or
+// updating a session. Page takes precedence over Percent when both are set.
+type readingProgressInput struct {
+	Page       *int       `json:"page"`
+	Percent    *float64   `json:"percent"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+func (input readingProgressInput) apply(session *data.ReadingSession, book *data.Book) {
+	switch {
+	case input.Page != nil:
+		session.SetPage(*input.Page, book.PageCount)
+	case input.Percent != nil:
+		session.SetPercent(*input.Percent, book.PageCount)
+	}
+	if input.StartedAt != nil {
+		session.StartedAt = *input.StartedAt
+	}
+	if input.FinishedAt != nil {
+		session.Finish(*input.FinishedAt, book.PageCount)
+	}
+}
+
+// createReadingSessionHandler starts a read of a book, or logs a past one
+// when finished_at is given.
+func (a *applicationDependencies) createReadingSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.readOwnUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		BookID int64 `json:"book_id"`
+		readingProgressInput
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	book, err := a.bookModel.Get(input.BookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("book_id", "unknown_book")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	session := &data.ReadingSession{
+		UserID:    userID,
+		BookID:    book.ID,
+		StartedAt: time.Now(),
+	}
+	input.apply(session, book)
+
+	data.ValidateReadingSession(v, session, book)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.readingSessionModel.Insert(session)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrActiveSession):
+			v.AddError("book_id", "reading_in_progress")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("book_id", "unknown_book")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/%d/reading-sessions/%d", session.UserID, session.ID))
+
+	data := envelope{"reading_session": session}
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) displayReadingSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, _, ok := a.readSession(w, r)
+	if !ok {
+		return
+	}
+
+	data := envelope{"reading_session": session}
+	err := a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateReadingSessionHandler records progress. Setting finished to true
+// finishes the session now; setting it to false reopens it.
+func (a *applicationDependencies) updateReadingSessionHandler(w http.ResponseWriter, r *http.Request) {
+	session, book, ok := a.readSession(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Finished *bool `json:"finished"`
+		readingProgressInput
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Finished != nil {
+		switch {
+		case !*input.Finished:
+			session.FinishedAt = nil
+		case session.FinishedAt == nil && input.FinishedAt == nil:
+			session.Finish(time.Now(), book.PageCount)
+		}
+	}
+	input.apply(session, book)
+
+	v := validator.New()
+	data.ValidateReadingSession(v, session, book)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.readingSessionModel.Update(session)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrActiveSession):
+			v.AddError("finished", "reading_in_progress")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"reading_session": session}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) deleteReadingSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.readOwnUserID(w, r)
+	if !ok {
+		return
+	}
+	sessionID, err := a.readSessionIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.readingSessionModel.Delete(userID, sessionID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"message": "reading session successfully deleted"}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) listReadingSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.readOwnUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		BookID int
+		Status string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.BookID = a.getSingleIntegerParameter(query, "book_id", 0, v)
+	input.Status = a.getSingleQueryParameter(query, "status", "")
+	input.Filters.Page = a.getSingleIntegerParameter(query, "page", 1, v)
+	input.Filters.PageSize = a.getSingleIntegerParameter(query, "page_size", 10, v)
+	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "-started_at")
+	input.Filters.SortSafeList = []string{"id", "started_at", "finished_at", "percent", "-id", "-started_at", "-finished_at", "-percent"}
+
+	if input.Status != "" {
+		v.Check(validator.PermittedValue(input.Status, "reading", "finished"), "status", "one_of", "values", "reading, finished")
+	}
+	data.ValidateFilters(v, input.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sessions, metadata, err := a.readingSessionModel.GetAllForUser(userID, int64(input.BookID), input.Status, input.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"reading_sessions": sessions,
+		"metadata":         metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// showChallengeHandler reports a user's progress toward their reading goal
+// for a year. Anyone may see it.
+func (a *applicationDependencies) showChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	year, err := a.readYearParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	challenge, err := a.readingSessionModel.GetChallenge(userID, year)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{"challenge": challenge}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// setChallengeGoalHandler sets how many books the caller means to finish in
+// a year.
+func (a *applicationDependencies) setChallengeGoalHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := a.readOwnUserID(w, r)
+	if !ok {
+		return
+	}
+	year, err := a.readYearParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Goal int `json:"goal"`
+	}
+
+	err = a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Goal > 0, "goal", "positive")
+	v.Check(input.Goal <= 1000, "goal", "max_value", "max", 1000)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.readingSessionModel.SetGoal(userID, year, input.Goal)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	challenge, err := a.readingSessionModel.GetChallenge(userID, year)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{"challenge": challenge}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
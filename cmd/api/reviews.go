@@ -2,13 +2,42 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/tchenbz/AWTtest3/internal/data"
 	"github.com/tchenbz/AWTtest3/internal/validator"
 )
 
-// createReviewHandler handles POST requests for creating a new review.
+// reviewInput is the body of a request that creates or replaces a review.
+type reviewInput struct {
+	Content   string     `json:"content"`
+	Author    string     `json:"author"`
+	Rating    int        `json:"rating"`
+	Draft     bool       `json:"draft"`
+	PublishAt *time.Time `json:"publish_at"`
+}
+
+// newReview builds the review the caller is creating from input.
+func (a *applicationDependencies) newReview(r *http.Request, bookID int64, input reviewInput) *data.Review {
+	user := a.contextGetUser(r)
+	return &data.Review{
+		BookID:  bookID,
+		Content: input.Content,
+		Author:  input.Author,
+		Rating:  input.Rating,
+		UserID:  user.ID,
+		Status:  a.initialReviewStatus(user),
+		// A scheduled review is a draft until its publish_at
+		Draft:     input.Draft || input.PublishAt != nil,
+		PublishAt: input.PublishAt,
+	}
+}
+
+// createReviewHandler handles POST requests for creating a new review. Each
+// user may review a book once; a second attempt gets a 409 pointing to the
+// existing review.
 func (a *applicationDependencies) createReviewHandler(w http.ResponseWriter, r *http.Request) {
 	bookID, err := a.readIDParam(r)
 	if err != nil {
@@ -16,11 +45,7 @@ func (a *applicationDependencies) createReviewHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	var input struct {
-		Content string `json:"content"`
-		Author  string `json:"author"`
-		Rating  int    `json:"rating"`
-	}
+	var input reviewInput
 
 	err = a.readJSON(w, r, &input)
 	if err != nil {
@@ -28,23 +53,26 @@ func (a *applicationDependencies) createReviewHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	review := &data.Review{
-		BookID: bookID,
-		Content:   input.Content,
-		Author:    input.Author,
-		Rating:    input.Rating,
-	}
+	review := a.newReview(r, bookID, input)
 
-	// v := validator.New()
-	// data.ValidateReview(v, review)
-	// if !v.IsEmpty() {
-	// 	a.failedValidationResponse(w, r, v.Errors)
-	// 	return
-	// }
+	v := validator.New()
+	if review.PublishAt != nil {
+		v.Check(review.PublishAt.After(time.Now()), "publish_at", "future")
+	}
+	data.ValidateReview(v, review, a.reviewPolicy.Review())
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	err = a.reviewModel.Insert(review)
+	err = a.reviewModel.Insert(review, a.newAuditEvent(r, "review", data.AuditActionCreate))
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			a.reviewExists(w, r, bookID, review.UserID)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -63,7 +91,7 @@ func (a *applicationDependencies) displayReviewHandler(w http.ResponseWriter, r
 		a.notFoundResponse(w, r)
 		return
 	}
-	reviewID, err := a.readIDParam(r)
+	reviewID, err := a.readReviewIDParam(r)
 	if err != nil {
 		a.notFoundResponse(w, r)
 		return
@@ -80,6 +108,10 @@ func (a *applicationDependencies) displayReviewHandler(w http.ResponseWriter, r
 		}
 		return
 	}
+	if !a.canViewReview(a.contextGetUser(r), review) {
+		a.notFoundResponse(w, r)
+		return
+	}
 
 	// Send the review data in JSON format
 	data := envelope{"review": review}
@@ -97,7 +129,7 @@ func (a *applicationDependencies) updateReviewHandler(w http.ResponseWriter, r *
 		a.notFoundResponse(w, r)
 		return
 	}
-	reviewID, err := a.readIDParam(r)
+	reviewID, err := a.readReviewIDParam(r)
 	if err != nil {
 		a.notFoundResponse(w, r)
 		return
@@ -114,47 +146,89 @@ func (a *applicationDependencies) updateReviewHandler(w http.ResponseWriter, r *
 		}
 		return
 	}
-
-	// Create a temporary struct for incoming updates
-	var input struct {
-		Content      *string `json:"content"`
-		Author       *string `json:"author"`
-		Rating       *int    `json:"rating"`
-		HelpfulCount *int    `json:"helpful_count"`
+	user := a.contextGetUser(r)
+	if !a.canViewReview(user, review) {
+		a.notFoundResponse(w, r)
+		return
 	}
-
-	// Decode the request JSON into the input struct
-	err = a.readJSON(w, r, &input)
-	if err != nil {
-		a.badRequestResponse(w, r, err)
+	if !a.canEditReview(user, review) {
+		a.notPermittedResponse(w, r)
 		return
 	}
+	original := *review
 
-	// Update the review fields as needed
-	if input.Content != nil {
-		review.Content = *input.Content
-	}
-	if input.Author != nil {
-		review.Author = *input.Author
-	}
-	if input.Rating != nil {
-		review.Rating = *input.Rating
-	}
-	if input.HelpfulCount != nil {
-		review.HelpfulCount = *input.HelpfulCount
+	if a.patchMediaType(r) != "" {
+		var patched struct {
+			Content   string     `json:"content"`
+			Author    string     `json:"author"`
+			Rating    int        `json:"rating"`
+			Draft     bool       `json:"draft"`
+			PublishAt *time.Time `json:"publish_at"`
+		}
+
+		err = a.readPatch(w, r, review, []string{"id", "book_id", "content_html", "content_text", "user_id", "helpful_count", "comment_count", "reactions", "status", "rejection_reason", "moderated_by", "moderated_at", "hidden", "created_at", "version"}, &patched)
+		if err != nil {
+			a.patchErrorResponse(w, r, err)
+			return
+		}
+
+		review.Content = patched.Content
+		review.Author = patched.Author
+		review.Rating = patched.Rating
+		review.Draft = patched.Draft
+		review.PublishAt = patched.PublishAt
+	} else {
+		// Create a temporary struct for incoming updates
+		var input struct {
+			Content   *string    `json:"content"`
+			Author    *string    `json:"author"`
+			Rating    *int       `json:"rating"`
+			Draft     *bool      `json:"draft"`
+			PublishAt *time.Time `json:"publish_at"`
+		}
+
+		// Decode the request JSON into the input struct
+		err = a.readJSON(w, r, &input)
+		if err != nil {
+			a.badRequestResponse(w, r, err)
+			return
+		}
+
+		// Update the review fields as needed
+		if input.Content != nil {
+			review.Content = *input.Content
+		}
+		if input.Author != nil {
+			review.Author = *input.Author
+		}
+		if input.Rating != nil {
+			review.Rating = *input.Rating
+		}
+		if input.Draft != nil {
+			review.Draft = *input.Draft
+		}
+		if input.PublishAt != nil {
+			review.PublishAt = input.PublishAt
+		}
 	}
 
-	// // Validate the updated review
-	// v := validator.New()
-	// data.ValidateReview(v, review)
-	// if !v.IsEmpty() {
-	// 	a.failedValidationResponse(w, r, v.Errors)
-	// 	return
-	// }
+	// Validate the updated review
+	v := validator.New()
+	a.checkReviewEdit(v, r, review, &original)
+	data.ValidateReview(v, review, a.reviewPolicy.Review())
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	err = a.reviewModel.Update(review)
+	err = a.reviewModel.Update(review, a.newAuditEvent(r, "review", data.AuditActionUpdate))
 	if err != nil {
-		a.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -171,13 +245,33 @@ func (a *applicationDependencies) deleteReviewHandler(w http.ResponseWriter, r *
 		a.notFoundResponse(w, r)
 		return
 	}
-	reviewID, err := a.readIDParam(r)
+	reviewID, err := a.readReviewIDParam(r)
 	if err != nil {
 		a.notFoundResponse(w, r)
 		return
 	}
 
-	err = a.reviewModel.Delete(bookID, reviewID)
+	review, err := a.reviewModel.Get(bookID, reviewID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	user := a.contextGetUser(r)
+	if !a.canViewReview(user, review) {
+		a.notFoundResponse(w, r)
+		return
+	}
+	if !a.canEditReview(user, review) {
+		a.notPermittedResponse(w, r)
+		return
+	}
+
+	err = a.reviewModel.Delete(bookID, reviewID, a.newAuditEvent(r, "review", data.AuditActionDelete))
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -219,7 +313,7 @@ func (a *applicationDependencies) listReviewsHandler(w http.ResponseWriter, r *h
 		return
 	}
 
-	reviews, metadata, err := a.reviewModel.GetAll(input.Content, input.Author, input.Rating, input.Filters)
+	reviews, metadata, err := a.reviewModel.GetAll(input.Content, input.Author, input.Rating, a.contextGetUser(r).ID, input.Filters)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -265,7 +359,7 @@ func (a *applicationDependencies) listBookReviewsHandler(w http.ResponseWriter,
 		return
 	}
 
-	reviews, metadata, err := a.reviewModel.GetAllForBook(bookID, input.Content, input.Author, input.Rating, input.Filters)
+	reviews, metadata, err := a.reviewModel.GetAllForBook(bookID, input.Content, input.Author, input.Rating, a.contextGetUser(r).ID, input.Filters)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -279,4 +373,179 @@ func (a *applicationDependencies) listBookReviewsHandler(w http.ResponseWriter,
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+// readVisibleReview loads the review named in the URL, which must be visible
+// to the caller. On failure the response has already been sent.
+func (a *applicationDependencies) readVisibleReview(w http.ResponseWriter, r *http.Request) (*data.Review, bool) {
+	bookID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+	reviewID, err := a.readReviewIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	review, err := a.reviewModel.Get(bookID, reviewID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	if !a.canViewReview(a.contextGetUser(r), review) {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return review, true
+}
+
+func samePublishAt(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// checkReviewEdit applies the rules for changing an existing review: the
+// author's edits to the content or rating go back through moderation, and a
+// published review can't be turned back into a draft or rescheduled. A
+// moderator's edit keeps the review's status; reviews are only approved or
+// rejected through the moderation endpoints.
+func (a *applicationDependencies) checkReviewEdit(v *validator.Validator, r *http.Request, review, original *data.Review) {
+	user := a.contextGetUser(r)
+	if user.ID == original.UserID && (review.Content != original.Content || review.Rating != original.Rating) {
+		review.Status = a.initialReviewStatus(user)
+		review.RejectionReason = ""
+	}
+
+	publishAtChanged := !samePublishAt(review.PublishAt, original.PublishAt)
+	switch {
+	case !original.Draft:
+		v.Check(!review.Draft && !publishAtChanged, "draft", "already_published")
+	case publishAtChanged && review.PublishAt != nil:
+		v.Check(review.PublishAt.After(time.Now()), "publish_at", "future")
+		review.Draft = true
+	}
+}
+
+// reviewExists sends the 409 for a second review of the same book by the
+// same user.
+func (a *applicationDependencies) reviewExists(w http.ResponseWriter, r *http.Request, bookID, userID int64) {
+	existing, err := a.reviewModel.GetForUser(bookID, userID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	a.reviewExistsResponse(w, r, fmt.Sprintf("/v1/books/%d/reviews/%d", existing.BookID, existing.ID))
+}
+
+// showMyReviewHandler returns the caller's review of a book, whatever its
+// status.
+func (a *applicationDependencies) showMyReviewHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	review, err := a.reviewModel.GetForUser(bookID, a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"review": review}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// putMyReviewHandler creates the caller's review of a book, or replaces it if
+// they already have one.
+func (a *applicationDependencies) putMyReviewHandler(w http.ResponseWriter, r *http.Request) {
+	bookID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var input reviewInput
+
+	err = a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	review, err := a.reviewModel.GetForUser(bookID, user.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	status := http.StatusOK
+
+	if review == nil {
+		status = http.StatusCreated
+		review = a.newReview(r, bookID, input)
+		if review.PublishAt != nil {
+			v.Check(review.PublishAt.After(time.Now()), "publish_at", "future")
+		}
+	} else {
+		original := *review
+		review.Content = input.Content
+		review.Author = input.Author
+		review.Rating = input.Rating
+		review.Draft = input.Draft
+		if input.PublishAt != nil {
+			review.PublishAt = input.PublishAt
+		}
+		a.checkReviewEdit(v, r, review, &original)
+	}
+
+	data.ValidateReview(v, review, a.reviewPolicy.Review())
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if status == http.StatusCreated {
+		err = a.reviewModel.Insert(review, a.newAuditEvent(r, "review", data.AuditActionCreate))
+	} else {
+		err = a.reviewModel.Update(review, a.newAuditEvent(r, "review", data.AuditActionUpdate))
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateReview):
+			a.reviewExists(w, r, bookID, user.ID)
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"review": review}
+	err = a.writeJSON(w, status, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
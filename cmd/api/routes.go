@@ -6,25 +6,110 @@ import (
 )
 
 func (a *applicationDependencies) routes() http.Handler {
+	router := a.resourceRouter()
+
+	router.HandlerFunc(http.MethodGet, "/v1/reviews/export", a.exportReviewsHandler)
+
+	router.HandlerFunc(http.MethodPost, "/v1/batch", a.batchHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/moderation/reviews", a.requirePermission("reviews:moderate", a.listModerationReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/moderation/reviews/:review_id/approve", a.requirePermission("reviews:moderate", a.approveReviewHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/moderation/reviews/:review_id/reject", a.requirePermission("reviews:moderate", a.rejectReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/moderation/comments", a.requirePermission("reviews:moderate", a.listModerationCommentsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/moderation/comments/:comment_id/approve", a.requirePermission("reviews:moderate", a.approveCommentHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/moderation/comments/:comment_id/reject", a.requirePermission("reviews:moderate", a.rejectCommentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/moderation/flagged-reviews", a.requirePermission("reviews:moderate", a.listFlaggedReviewsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/shelves", a.listShelvesHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/shelves", a.requireAuthenticatedUser(a.createShelfHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/shelves/:slug", a.displayShelfHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:id/shelves/:slug", a.requireAuthenticatedUser(a.updateShelfHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/shelves/:slug", a.requireAuthenticatedUser(a.deleteShelfHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/shelves/:slug/books", a.listShelfBooksHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/:id/shelves/:slug/books/:book_id", a.requireAuthenticatedUser(a.addShelfBookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/shelves/:slug/books/:book_id", a.requireAuthenticatedUser(a.removeShelfBookHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/reading-sessions", a.requireAuthenticatedUser(a.listReadingSessionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/:id/reading-sessions", a.requireAuthenticatedUser(a.createReadingSessionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/reading-sessions/:session_id", a.requireAuthenticatedUser(a.displayReadingSessionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/:id/reading-sessions/:session_id", a.requireAuthenticatedUser(a.updateReadingSessionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/reading-sessions/:session_id", a.requireAuthenticatedUser(a.deleteReadingSessionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/challenges/:year", a.showChallengeHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/:id/challenges/:year", a.requireAuthenticatedUser(a.setChallengeGoalHandler))
+
+	router.HandlerFunc(http.MethodPut, "/v1/users/:id/follow", a.requireAuthenticatedUser(a.followUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/:id/follow", a.requireAuthenticatedUser(a.unfollowUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/followers", a.listFollowersHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/:id/following", a.listFollowingHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/feed", a.requireAuthenticatedUser(a.showFeedHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/notifications", a.requireAuthenticatedUser(a.listNotificationsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/notifications/:id/read", a.requireAuthenticatedUser(a.markNotificationReadHandler))
+
+	router.HandlerFunc(http.MethodPost, "/v1/users", a.registerUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", a.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", a.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", a.createPasswordResetTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys", a.requireSessionUser(a.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/api-keys", a.requireSessionUser(a.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/api-keys/:id", a.requireSessionUser(a.displayAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/api-keys/:id", a.requireSessionUser(a.revokeAPIKeyHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/api-keys/:id/rotate", a.requireSessionUser(a.rotateAPIKeyHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/admin/audit", a.requirePermission("audit:read", a.listAuditEventsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/review-policy", a.requirePermission("policy:manage", a.showReviewPolicyHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/review-policy/reload", a.requirePermission("policy:manage", a.reloadReviewPolicyHandler))
+
+	// httprouter can't register a static segment next to the :id wildcard,
+	// so those routes are matched by a ServeMux in front of it.
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/books/import", a.requireActivatedUser(a.importBooksHandler))
+	mux.HandleFunc("GET /v1/books/export", a.exportBooksHandler)
+	mux.HandleFunc("GET /v1/books/{id}/reviews/mine", a.requireAuthenticatedUser(a.showMyReviewHandler))
+	mux.HandleFunc("PUT /v1/books/{id}/reviews/mine", a.requireActivatedUser(a.putMyReviewHandler))
+	mux.HandleFunc("POST /v1/notifications/read-all", a.requireAuthenticatedUser(a.markAllNotificationsReadHandler))
+	mux.HandleFunc("GET /v1/notifications/preferences", a.requireAuthenticatedUser(a.showNotificationPreferencesHandler))
+	mux.HandleFunc("PUT /v1/notifications/preferences", a.requireAuthenticatedUser(a.updateNotificationPreferencesHandler))
+	mux.HandleFunc("PUT /v1/users/activated", a.activateUserHandler)
+	mux.HandleFunc("PUT /v1/users/password", a.updateUserPasswordHandler)
+	mux.Handle("/", router)
+
+	//return a.recoverPanic(router)
+	return a.recoverPanic(a.requestID(a.rateLimit(a.authenticate(a.authenticateAPIKey(mux)))))
+}
+
+// resourceRouter holds the book and review routes, which are also the routes
+// a batch request may call. Writing to them needs an activated account.
+func (a *applicationDependencies) resourceRouter() *httprouter.Router {
 	router := httprouter.New()
 	router.NotFound = http.HandlerFunc(a.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(a.methodNotAllowedResponse)
 
-	router.HandlerFunc(http.MethodPost, "/v1/books", a.createBookHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/books", a.requireActivatedUser(a.idempotent(a.createBookHandler)))
 	router.HandlerFunc(http.MethodGet, "/v1/books/:id", a.displayBookHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/books/:id", a.updateBookHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/books/:id", a.deleteBookHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/books/:id", a.requireActivatedUser(a.updateBookHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/books/:id", a.requireActivatedUser(a.deleteBookHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/books", a.listBooksHandler)
 
-	router.HandlerFunc(http.MethodPost, "/v1/books/:id/reviews", a.createReviewHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/books/:id/reviews", a.requireActivatedUser(a.idempotent(a.createReviewHandler)))
 	router.HandlerFunc(http.MethodGet, "/v1/books/:id/reviews/:review_id", a.displayReviewHandler)
-	router.HandlerFunc(http.MethodPatch, "/v1/books/:id/reviews/:review_id", a.updateReviewHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/books/:id/reviews/:review_id", a.deleteReviewHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/books/:id/reviews/:review_id", a.requireActivatedUser(a.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/books/:id/reviews/:review_id", a.requireActivatedUser(a.deleteReviewHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/reviews", a.listReviewsHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/books/:id/reviews", a.listBookReviewsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/books/:id/reviews/:review_id/flags", a.requireActivatedUser(a.createFlagHandler))
 
-	//return a.recoverPanic(router)
-	return a.recoverPanic(a.rateLimit(router))
-}
+	router.HandlerFunc(http.MethodPut, "/v1/books/:id/reviews/:review_id/reactions/:reaction", a.requireActivatedUser(a.addReactionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/books/:id/reviews/:review_id/reactions/:reaction", a.requireActivatedUser(a.removeReactionHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/books/:id/reviews/:review_id/helpful", a.requireActivatedUser(a.addHelpfulVoteHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/books/:id/reviews/:review_id/helpful", a.requireActivatedUser(a.removeHelpfulVoteHandler))
 
+	router.HandlerFunc(http.MethodGet, "/v1/books/:id/reviews/:review_id/comments", a.listCommentsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/books/:id/reviews/:review_id/comments", a.requireActivatedUser(a.createCommentHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/books/:id/reviews/:review_id/comments/:comment_id", a.displayCommentHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/books/:id/reviews/:review_id/comments/:comment_id", a.requireActivatedUser(a.updateCommentHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/books/:id/reviews/:review_id/comments/:comment_id", a.requireActivatedUser(a.deleteCommentHandler))
 
+	return router
+}
@@ -0,0 +1,19 @@
+package main
+
+import "time"
+
+// publishScheduledReviews publishes drafts once their publish_at has passed.
+// It checks every minute for the life of the process.
+func (a *applicationDependencies) publishScheduledReviews() {
+	for {
+		time.Sleep(time.Minute)
+		n, err := a.reviewModel.PublishDue()
+		if err != nil {
+			a.logger.Error(err.Error())
+			continue
+		}
+		if n > 0 {
+			a.logger.Info("published scheduled reviews", "count", n)
+		}
+	}
+}
@@ -20,6 +20,10 @@ func (a *applicationDependencies)serve() error {
         WriteTimeout: 10 * time.Second,
         ErrorLog: slog.NewLogLogger(a.logger.Handler(), slog.LevelError),
     }
+	go a.purgeExpiredIdempotencyKeys()
+	go a.reloadReviewPolicyOnSignal()
+	go a.publishScheduledReviews()
+
 	shutdownError := make(chan error)
 	go func() {
 		quit := make(chan os.Signal, 1) 
@@ -28,7 +32,13 @@ func (a *applicationDependencies)serve() error {
 		a.logger.Info("shutting down server", "signal", s.String())
 	   ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	   defer cancel()
-		shutdownError <- apiServer.Shutdown(ctx)
+		err := apiServer.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+		a.logger.Info("completing queued emails")
+		shutdownError <- a.mailer.Close(ctx)
 		}()
  
 
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// readShelf loads the shelf named in the URL, which must be visible to the
+// caller. Built-in shelves are created on the owner's first visit. On
+// failure the response has already been sent.
+func (a *applicationDependencies) readShelf(w http.ResponseWriter, r *http.Request) (*data.Shelf, bool) {
+	userID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+	owner := a.isCurrentUser(r, userID)
+
+	if owner {
+		err = a.shelfModel.EnsureBuiltIns(userID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return nil, false
+		}
+	}
+
+	slug := httprouter.ParamsFromContext(r.Context()).ByName("slug")
+	shelf, err := a.shelfModel.Get(userID, slug)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+	if !shelf.Public && !owner {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	return shelf, true
+}
+
+// readOwnShelf is readShelf for changes, which only the owner may make.
+func (a *applicationDependencies) readOwnShelf(w http.ResponseWriter, r *http.Request) (*data.Shelf, bool) {
+	shelf, ok := a.readShelf(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !a.isCurrentUser(r, shelf.UserID) {
+		a.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return shelf, true
+}
+
+// listShelvesHandler lists a user's shelves. Other callers only see the
+// public ones.
+func (a *applicationDependencies) listShelvesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	owner := a.isCurrentUser(r, userID)
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.Filters.Page = a.getSingleIntegerParameter(query, "page", 1, v)
+	input.Filters.PageSize = a.getSingleIntegerParameter(query, "page_size", 10, v)
+	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "id")
+	input.Filters.SortSafeList = []string{"id", "name", "created_at", "book_count", "-id", "-name", "-created_at", "-book_count"}
+
+	data.ValidateFilters(v, input.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if owner {
+		err = a.shelfModel.EnsureBuiltIns(userID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	shelves, metadata, err := a.shelfModel.GetAllForUser(userID, owner, input.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"shelves":  shelves,
+		"metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// createShelfHandler creates a tag shelf for the caller.
+func (a *applicationDependencies) createShelfHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	if !a.isCurrentUser(r, userID) {
+		a.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Name   string `json:"name"`
+		Public *bool  `json:"public"`
+	}
+
+	err = a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	shelf := &data.Shelf{
+		UserID: userID,
+		Name:   input.Name,
+		Slug:   data.Slugify(input.Name),
+		Public: input.Public == nil || *input.Public,
+	}
+
+	v := validator.New()
+	data.ValidateShelf(v, shelf)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.shelfModel.Insert(shelf, a.newAuditEvent(r, "shelf", data.AuditActionCreate))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateShelf):
+			v.AddError("name", "shelf_exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/users/%d/shelves/%s", shelf.UserID, shelf.Slug))
+
+	data := envelope{"shelf": shelf}
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) displayShelfHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := a.readShelf(w, r)
+	if !ok {
+		return
+	}
+
+	data := envelope{"shelf": shelf}
+	err := a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateShelfHandler renames a tag shelf or changes a shelf's visibility.
+// Renaming changes the slug, and so the shelf's URL.
+func (a *applicationDependencies) updateShelfHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := a.readOwnShelf(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name   *string `json:"name"`
+		Public *bool   `json:"public"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil && *input.Name != shelf.Name {
+		if shelf.Exclusive {
+			a.builtInShelfResponse(w, r)
+			return
+		}
+		shelf.Name = *input.Name
+		shelf.Slug = data.Slugify(*input.Name)
+	}
+	if input.Public != nil {
+		shelf.Public = *input.Public
+	}
+
+	v := validator.New()
+	data.ValidateShelf(v, shelf)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.shelfModel.Update(shelf, a.newAuditEvent(r, "shelf", data.AuditActionUpdate))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateShelf):
+			v.AddError("name", "shelf_exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"shelf": shelf}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) deleteShelfHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := a.readOwnShelf(w, r)
+	if !ok {
+		return
+	}
+	if shelf.Exclusive {
+		a.builtInShelfResponse(w, r)
+		return
+	}
+
+	err := a.shelfModel.Delete(shelf.ID, a.newAuditEvent(r, "shelf", data.AuditActionDelete))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"message": "shelf successfully deleted"}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) listShelfBooksHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := a.readShelf(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	query := r.URL.Query()
+	input.Filters.Page = a.getSingleIntegerParameter(query, "page", 1, v)
+	input.Filters.PageSize = a.getSingleIntegerParameter(query, "page_size", 10, v)
+	input.Filters.Sort = a.getSingleQueryParameter(query, "sort", "-added_at")
+	input.Filters.SortSafeList = []string{"added_at", "title", "author", "average_rating", "-added_at", "-title", "-author", "-average_rating"}
+
+	data.ValidateFilters(v, input.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := a.shelfModel.GetBooks(shelf.ID, input.Filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"books":    entries,
+		"metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// addShelfBookHandler puts a book on one of the caller's shelves. On a
+// built-in shelf this moves the book off the other built-in shelves.
+func (a *applicationDependencies) addShelfBookHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := a.readOwnShelf(w, r)
+	if !ok {
+		return
+	}
+	bookID, err := a.readBookIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	entry, err := a.shelfModel.AddBook(shelf, bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"entry": entry}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) removeShelfBookHandler(w http.ResponseWriter, r *http.Request) {
+	shelf, ok := a.readOwnShelf(w, r)
+	if !ok {
+		return
+	}
+	bookID, err := a.readBookIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.shelfModel.RemoveBook(shelf, bookID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{"message": "book successfully removed from shelf"}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tchenbz/AWTtest3/internal/data"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+const (
+	authenticationTokenTTL   = 24 * time.Hour
+	passwordResetTokenTTL    = 45 * time.Minute
+	activationTokenTTL       = 3 * 24 * time.Hour
+	activationResendInterval = 5 * time.Minute
+	passwordResetInterval    = 5 * time.Minute
+)
+
+// mailLimiter allows one email per address per interval. It is keyed on the
+// address asked for, whether or not an account has it, so being limited
+// says nothing about who has an account.
+type mailLimiter struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	sent      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newMailLimiter(interval time.Duration) *mailLimiter {
+	return &mailLimiter{interval: interval, sent: make(map[string]time.Time)}
+}
+
+func (l *mailLimiter) allow(email string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastPrune) > l.interval {
+		for key, sentAt := range l.sent {
+			if now.Sub(sentAt) > l.interval {
+				delete(l.sent, key)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	key := strings.ToLower(email)
+	if sentAt, ok := l.sent[key]; ok && now.Sub(sentAt) <= l.interval {
+		return false
+	}
+	l.sent[key] = now
+	return true
+}
+
+// sendActivationToken emails the user a new activation token. Delivery
+// failures are only logged.
+func (a *applicationDependencies) sendActivationToken(r *http.Request, user *data.User) error {
+	token, err := a.tokenModel.New(user.ID, activationTokenTTL, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	mail := map[string]any{
+		"userID":          user.ID,
+		"activationToken": token.Plaintext,
+		"expiry":          token.Expiry.UTC().Format(time.RFC1123),
+	}
+	err = a.mailer.Send(user.Email, "token_activation.tmpl", mail)
+	if err != nil {
+		a.logError(r, err)
+	}
+	return nil
+}
+
+// createActivationTokenHandler emails a new activation token to the inactive
+// account with the given email. Like password resets, the response doesn't
+// reveal whether there is such an account.
+func (a *applicationDependencies) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if a.config.limiter.enabled && !a.activationLimiter.allow(input.Email) {
+		a.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	user, err := a.userModel.GetByEmail(input.Email)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+	case err != nil:
+		a.serverErrorResponse(w, r, err)
+		return
+	case !user.Activated:
+		err = a.sendActivationToken(r, user)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	data := envelope{"message": "if an inactive account with that email address exists, you will receive activation instructions"}
+	err = a.writeJSON(w, http.StatusAccepted, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// createAuthenticationTokenHandler signs the user in, returning a bearer
+// token whose sub is their users.id. The token carries no permissions;
+// those are only in tokens signed elsewhere with the same -jwt-secret.
+func (a *applicationDependencies) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Password != "", "password", "required")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// Unknown addresses still pay for a bcrypt comparison, so timing doesn't
+	// reveal which accounts exist.
+	match, err := data.PasswordMatches(user, input.Password)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		a.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	now := time.Now()
+	expiry := now.Add(authenticationTokenTTL)
+	claims := jwt.MapClaims{
+		"sub": strconv.FormatInt(user.ID, 10),
+		"iat": now.Unix(),
+		"exp": expiry.Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(a.config.jwt.secret))
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	data := envelope{"authentication_token": envelope{"token": token, "expiry": expiry.UTC()}}
+	err = a.writeJSON(w, http.StatusCreated, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler emails a password reset token to the
+// account with the given email. The response is the same whether or not
+// the account exists, so it can't be used to find out who has one.
+func (a *applicationDependencies) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &input)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if a.config.limiter.enabled && !a.passwordResetLimiter.allow(input.Email) {
+		a.rateLimitExceededResponse(w, r)
+		return
+	}
+
+	user, err := a.userModel.GetByEmail(input.Email)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+	case err != nil:
+		a.serverErrorResponse(w, r, err)
+		return
+	default:
+		token, err := a.tokenModel.New(user.ID, passwordResetTokenTTL, data.ScopePasswordReset)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		mail := map[string]any{
+			"passwordResetToken": token.Plaintext,
+			"expiry":             token.Expiry.UTC().Format(time.RFC1123),
+		}
+		err = a.mailer.Send(user.Email, "token_password_reset.tmpl", mail)
+		if err != nil {
+			a.logError(r, err)
+		}
+	}
+
+	data := envelope{"message": "if an account with that email address exists, you will receive password reset instructions"}
+	err = a.writeJSON(w, http.StatusAccepted, data, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
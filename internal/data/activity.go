@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	ActivityReview = "review"
+	ActivityRating = "rating"
+	ActivityShelve = "shelve"
+)
+
+// feedBackfill is how many of a user's latest activities are copied into a
+// new follower's timeline, so the feed isn't empty until they next act.
+const feedBackfill = 50
+
+// Activity is something a user did that their followers see in their feed:
+// publishing a review, changing a published review's rating, or putting a
+// book on a public shelf.
+type Activity struct {
+	ID             int64     `json:"id"`
+	ActorID        int64     `json:"actor_id"`
+	Verb           string    `json:"verb"`
+	BookID         int64     `json:"book_id"`
+	BookTitle      string    `json:"book_title"`
+	ReviewID       int64     `json:"review_id,omitempty"`
+	ShelfID        int64     `json:"-"`
+	Shelf          string    `json:"shelf,omitempty"`
+	ShelfName      string    `json:"shelf_name,omitempty"`
+	Rating         int       `json:"rating,omitempty"`
+	PreviousRating int       `json:"previous_rating,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// recordActivity stores an activity and fans it out to the timelines of the
+// actor's followers, so reading a feed is a single indexed lookup. A review
+// is only announced once; recording it again reports false.
+func recordActivity(ctx context.Context, tx *sql.Tx, activity *Activity) (bool, error) {
+	insertQuery := `
+		INSERT INTO activities (actor_id, verb, book_id, review_id, shelf_id, rating, previous_rating)
+		VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, 0), $6, $7)
+		ON CONFLICT DO NOTHING
+		RETURNING id, created_at`
+
+	fanOutQuery := `
+		INSERT INTO timelines (user_id, activity_id, actor_id)
+		SELECT follower_id, $1, $2
+		FROM follows
+		WHERE followee_id = $2`
+
+	args := []interface{}{activity.ActorID, activity.Verb, activity.BookID, activity.ReviewID, activity.ShelfID, activity.Rating, activity.PreviousRating}
+
+	err := tx.QueryRowContext(ctx, insertQuery, args...).Scan(&activity.ID, &activity.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, fanOutQuery, activity.ID, activity.ActorID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// recordReviewActivity announces a review once it is visible to everyone,
+// and afterwards any change to its rating. previousRating is the rating
+// before the change, or 0 for a new review.
+func recordReviewActivity(ctx context.Context, tx *sql.Tx, review *Review, previousRating int) error {
+	if review.UserID == 0 || review.Status != ReviewStatusApproved || review.Hidden || review.Draft {
+		return nil
+	}
+
+	recorded, err := recordActivity(ctx, tx, &Activity{
+		ActorID:  review.UserID,
+		Verb:     ActivityReview,
+		BookID:   review.BookID,
+		ReviewID: review.ID,
+		Rating:   review.Rating,
+	})
+	if err != nil || recorded || previousRating == 0 || previousRating == review.Rating {
+		return err
+	}
+
+	_, err = recordActivity(ctx, tx, &Activity{
+		ActorID:        review.UserID,
+		Verb:           ActivityRating,
+		BookID:         review.BookID,
+		ReviewID:       review.ID,
+		Rating:         review.Rating,
+		PreviousRating: previousRating,
+	})
+	return err
+}
+
+type ActivityModel struct {
+	DB DBTX
+}
+
+// GetFeed returns the activity of the users userID follows, newest first.
+// Activities whose review or shelf is no longer public are skipped.
+func (m ActivityModel) GetFeed(userID int64, filters CursorFilters) ([]*Activity, CursorMetadata, error) {
+	// The cursor is the last activity ID seen; older activities have
+	// smaller IDs.
+	query := `
+		SELECT activities.id, activities.actor_id, activities.verb, activities.book_id, books.title,
+			COALESCE(activities.review_id, 0), COALESCE(activities.shelf_id, 0),
+			COALESCE(shelves.slug, ''), COALESCE(shelves.name, ''),
+			activities.rating, activities.previous_rating, activities.created_at
+		FROM timelines
+		JOIN activities ON activities.id = timelines.activity_id
+		JOIN books ON books.id = activities.book_id
+		LEFT JOIN reviews ON reviews.id = activities.review_id
+		LEFT JOIN shelves ON shelves.id = activities.shelf_id
+		WHERE timelines.user_id = $1
+		AND ($2 = 0 OR timelines.activity_id < $2)
+		AND (activities.review_id IS NULL OR (reviews.status = 'approved' AND NOT reviews.hidden AND NOT reviews.draft))
+		AND (activities.shelf_id IS NULL OR shelves.public)
+		ORDER BY timelines.activity_id DESC
+		LIMIT $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.after(), filters.Limit+1)
+	if err != nil {
+		return nil, CursorMetadata{}, err
+	}
+	defer rows.Close()
+
+	activities := []*Activity{}
+
+	for rows.Next() {
+		var activity Activity
+		err := rows.Scan(
+			&activity.ID, &activity.ActorID, &activity.Verb, &activity.BookID, &activity.BookTitle,
+			&activity.ReviewID, &activity.ShelfID, &activity.Shelf, &activity.ShelfName,
+			&activity.Rating, &activity.PreviousRating, &activity.CreatedAt,
+		)
+		if err != nil {
+			return nil, CursorMetadata{}, err
+		}
+		activities = append(activities, &activity)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, CursorMetadata{}, err
+	}
+
+	metadata, n := cursorMetadata(filters, len(activities), func(i int) int64 { return activities[i].ID })
+	return activities[:n], metadata, nil
+}
@@ -0,0 +1,344 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// ErrAPIKeyRevoked is returned when rotating a key that has been revoked or
+// already rotated.
+var ErrAPIKeyRevoked = errors.New("api key revoked")
+
+// APIKeyScopeWrite lets a key make requests other than GET and HEAD.
+const APIKeyScopeWrite = "write"
+
+// APIKeyScopes are the scopes a key can be given: APIKeyScopeWrite and the
+// permission codes checked by requirePermission. A request made with a key
+// has the key's scopes as its permissions.
+var APIKeyScopes = []string{APIKeyScopeWrite, "reviews:moderate", "audit:read", "policy:manage"}
+
+// apiKeyPrefixLength is how much of a key is stored in the clear, so the
+// owner can tell their keys apart.
+const apiKeyPrefixLength = 11
+
+// maxPrivilegedAPIKeyLifetime is how far ahead a key with permission scopes
+// may expire.
+const maxPrivilegedAPIKeyLifetime = 90 * 24 * time.Hour
+
+// APIKey lets a service act as the user who created it, limited to the
+// key's scopes. Only the SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name" validate:"required,max_len=100"`
+	Prefix     string     `json:"prefix"`
+	Hash       []byte     `json:"-"`
+	Scopes     []string   `json:"scopes" validate:"unique"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy *int64     `json:"replaced_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// apiKeyColumns matches the order of APIKey.scanFields.
+const apiKeyColumns = `id, user_id, name, prefix, hash, scopes, expires_at, last_used_at, revoked_at, replaced_by, created_at`
+
+func (k *APIKey) scanFields() []any {
+	return []any{
+		&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.Hash, (*pq.StringArray)(&k.Scopes),
+		&k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt, &k.ReplacedBy, &k.CreatedAt,
+	}
+}
+
+// NewAPIKey generates a key for the user and returns it with its plaintext,
+// which is shown to the user once and never stored.
+func NewAPIKey(userID int64, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	plaintext := "bk_" + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes))
+
+	key := &APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    plaintext[:apiKeyPrefixLength],
+		Hash:      hashToken(plaintext),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	return key, plaintext, nil
+}
+
+// ValidateAPIKey checks a new key. Its creator can only hand out the
+// permissions they hold, and the write scope only once activated.
+func ValidateAPIKey(v *validator.Validator, key *APIKey, creator *User) {
+	v.Struct(key)
+	v.Check(len(key.Scopes) > 0, "scopes", "min_items", "min", 1)
+
+	for i, scope := range key.Scopes {
+		if !validator.PermittedValue(scope, APIKeyScopes...) {
+			v.AddError(validator.Key("scopes", i), "one_of", "values", strings.Join(APIKeyScopes, ", "))
+			continue
+		}
+		held := creator.Permissions.Include(scope) || (scope == APIKeyScopeWrite && creator.Activated)
+		v.Check(held, validator.Key("scopes", i), "scope_not_held")
+	}
+
+	if key.ExpiresAt != nil {
+		v.Check(key.ExpiresAt.After(time.Now()), "expires_at", "future")
+	}
+
+	// Scopes are only checked against the creator's permissions here, so a
+	// key granting any permission must expire: it can outlive the
+	// permission otherwise.
+	if key.privileged() {
+		v.Check(key.ExpiresAt != nil, "expires_at", "required")
+		if key.ExpiresAt != nil {
+			v.Check(time.Until(*key.ExpiresAt) <= maxPrivilegedAPIKeyLifetime, "expires_at", "max_days_ahead", "max", int(maxPrivilegedAPIKeyLifetime/(24*time.Hour)))
+		}
+	}
+}
+
+// privileged reports whether the key has any scope besides write.
+func (k *APIKey) privileged() bool {
+	for _, scope := range k.Scopes {
+		if scope != APIKeyScopeWrite {
+			return true
+		}
+	}
+	return false
+}
+
+type APIKeyModel struct {
+	DB DBTX
+}
+
+func insertAPIKey(ctx context.Context, tx *sql.Tx, key *APIKey) error {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+
+	args := []any{key.UserID, key.Name, key.Prefix, key.Hash, pq.Array(key.Scopes), key.ExpiresAt}
+	return tx.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (m APIKeyModel) Insert(key *APIKey, event *AuditEvent) error {
+	return withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		err := insertAPIKey(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		event.ResourceID = key.ID
+		event.After, err = snapshot(key)
+		return err
+	})
+}
+
+func (m APIKeyModel) Get(userID, id int64) (*APIKey, error) {
+	if userID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE user_id = $1 AND id = $2`
+
+	var key APIKey
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, id).Scan(key.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64, filters Filters) ([]*APIKey, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM api_keys
+		WHERE user_id = $1
+		ORDER BY %s %s NULLS LAST, id ASC
+		LIMIT $2 OFFSET $3`, apiKeyColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+		err := rows.Scan(append([]any{&totalRecords}, key.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		keys = append(keys, &key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return keys, metadata, nil
+}
+
+// Revoke stops the key working now, including a rotated key still inside
+// its overlap window. Revoking a revoked key changes nothing.
+func (m APIKeyModel) Revoke(key *APIKey, event *AuditEvent) error {
+	query := `
+		UPDATE api_keys
+		SET revoked_at = LEAST(COALESCE(revoked_at, NOW()), NOW())
+		WHERE id = $1
+		RETURNING ` + apiKeyColumns
+
+	return withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		event.ResourceID = key.ID
+		before, err := snapshot(key)
+		if err != nil {
+			return err
+		}
+		event.Before = before
+
+		err = tx.QueryRowContext(ctx, query, key.ID).Scan(key.scanFields()...)
+		if err != nil {
+			return err
+		}
+
+		event.After, err = snapshot(key)
+		return err
+	})
+}
+
+// Rotate replaces key with a new one with the same name, scopes and expiry.
+// The old key keeps working for overlap, so services can switch over
+// without downtime. It returns the new key and its plaintext, and
+// ErrAPIKeyRevoked if key was revoked or has already been rotated.
+func (m APIKeyModel) Rotate(key *APIKey, overlap time.Duration, event *AuditEvent) (*APIKey, string, error) {
+	lockQuery := `
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys
+		WHERE id = $1
+		FOR UPDATE`
+
+	updateQuery := `
+		UPDATE api_keys
+		SET revoked_at = NOW() + $1 * INTERVAL '1 second', replaced_by = $2
+		WHERE id = $3
+		RETURNING ` + apiKeyColumns
+
+	replacement, plaintext, err := NewAPIKey(key.UserID, key.Name, key.Scopes, key.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, lockQuery, key.ID).Scan(key.scanFields()...)
+		if err != nil {
+			return err
+		}
+		if key.RevokedAt != nil || key.ReplacedBy != nil {
+			return ErrAPIKeyRevoked
+		}
+
+		event.ResourceID = key.ID
+		event.Before, err = snapshot(key)
+		if err != nil {
+			return err
+		}
+
+		err = insertAPIKey(ctx, tx, replacement)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, updateQuery, int64(overlap/time.Second), replacement.ID, key.ID).Scan(key.scanFields()...)
+		if err != nil {
+			return err
+		}
+
+		event.After, err = snapshot(key)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return replacement, plaintext, nil
+}
+
+// Authenticate finds the live key with the given plaintext, records that it
+// was used, and returns the user it acts as. Last use is recorded at most
+// once a minute. An unknown, expired or revoked key is ErrRecordNotFound.
+func (m APIKeyModel) Authenticate(plaintext string) (*User, error) {
+	query := `
+		WITH key AS (
+			SELECT id, user_id, scopes
+			FROM api_keys
+			WHERE hash = $1
+			AND (expires_at IS NULL OR expires_at > NOW())
+			AND (revoked_at IS NULL OR revoked_at > NOW())
+		), used AS (
+			UPDATE api_keys
+			SET last_used_at = NOW()
+			FROM key
+			WHERE api_keys.id = key.id
+			AND (api_keys.last_used_at IS NULL OR api_keys.last_used_at < NOW() - INTERVAL '1 minute')
+		)
+		SELECT key.id, key.user_id, key.scopes,
+			COALESCE((SELECT activated FROM users WHERE id = key.user_id), false)
+		FROM key`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var user User
+
+	err := m.DB.QueryRowContext(ctx, query, hashToken(plaintext)).Scan(
+		&user.APIKeyID,
+		&user.ID,
+		(*pq.StringArray)(&user.Permissions),
+		&user.Activated,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,169 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	AuditActionCreate  = "create"
+	AuditActionUpdate  = "update"
+	AuditActionDelete  = "delete"
+	AuditActionImport  = "import"
+	AuditActionApprove = "approve"
+	AuditActionReject  = "reject"
+	AuditActionPublish = "publish"
+	AuditActionRotate  = "rotate"
+	AuditActionRevoke  = "revoke"
+)
+
+// AuditEvent is one row of the append-only audit_events table. Before and
+// After hold JSON snapshots of the resource around the mutation.
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	ActorID    int64           `json:"actor_id,omitempty"`
+	IP         string          `json:"ip"`
+	RequestID  string          `json:"request_id"`
+	Resource   string          `json:"resource"`
+	ResourceID int64           `json:"resource_id"`
+	Action     string          `json:"action"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+// withAudit runs fn inside a transaction and records event in the same
+// transaction, so a mutation is never committed without its audit row. If db
+// is already a transaction, both run inside it.
+func withAudit(db DBTX, event *AuditEvent, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	return withAuditTimeout(db, 3*time.Second, event, fn)
+}
+
+func withAuditTimeout(db DBTX, timeout time.Duration, event *AuditEvent, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	scope, err := begin(ctx, db, nil)
+	if err != nil {
+		return err
+	}
+	defer scope.rollback()
+
+	err = fn(ctx, scope.tx)
+	if err != nil {
+		return err
+	}
+
+	err = insertAuditEvent(ctx, scope.tx, event)
+	if err != nil {
+		return err
+	}
+
+	return scope.commit()
+}
+
+func insertAuditEvent(ctx context.Context, tx *sql.Tx, event *AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (actor_id, ip, request_id, resource, resource_id, action, before, after)
+		VALUES (NULLIF($1, 0), $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, created_at`
+
+	args := []interface{}{
+		event.ActorID,
+		event.IP,
+		event.RequestID,
+		event.Resource,
+		event.ResourceID,
+		event.Action,
+		nullableJSON(event.Before),
+		nullableJSON(event.After),
+	}
+
+	return tx.QueryRowContext(ctx, query, args...).Scan(&event.ID, &event.CreatedAt)
+}
+
+func snapshot(v any) (json.RawMessage, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(js), nil
+}
+
+func nullableJSON(js json.RawMessage) interface{} {
+	if len(js) == 0 {
+		return nil
+	}
+	return []byte(js)
+}
+
+// GetAll returns audit events, newest first. A zero actorID or a nil from/to
+// disables that filter.
+func (m AuditModel) GetAll(resource string, actorID int64, from, to *time.Time, filters Filters) ([]*AuditEvent, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), id, COALESCE(actor_id, 0), ip, request_id, resource, resource_id,
+		       action, COALESCE(before, 'null'), COALESCE(after, 'null'), created_at
+		FROM audit_events
+		WHERE (resource = $1 OR $1 = '')
+		AND (actor_id = $2 OR $2 = 0)
+		AND ($3::timestamptz IS NULL OR created_at >= $3)
+		AND ($4::timestamptz IS NULL OR created_at < $4)
+		ORDER BY %s %s, id DESC
+		LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{resource, actorID, from, to, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	events := []*AuditEvent{}
+
+	for rows.Next() {
+		var event AuditEvent
+		var before, after []byte
+		err := rows.Scan(
+			&totalRecords,
+			&event.ID,
+			&event.ActorID,
+			&event.IP,
+			&event.RequestID,
+			&event.Resource,
+			&event.ResourceID,
+			&event.Action,
+			&before,
+			&after,
+			&event.CreatedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if string(before) != "null" {
+			event.Before = before
+		}
+		if string(after) != "null" {
+			event.After = after
+		}
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return events, metadata, nil
+}
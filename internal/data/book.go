@@ -7,61 +7,87 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/tchenbz/AWTtest3/internal/validator"
 )
 
 type Book struct {
 	ID            int64     `json:"id"`
-	Title         string    `json:"title"`
-	Author   	  string    `json:"author"`
-	Genre      	  string    `json:"genre"`
+	Title         string    `json:"title" validate:"required,max_len=500"`
+	Author   	  string    `json:"author" validate:"required,max_len=500"`
+	Genre      	  string    `json:"genre" validate:"max_len=100"`
+	PageCount     int       `json:"page_count" validate:"min_value=0,max_value=100000"`
 	AverageRating float32   `json:"average_rating"`
+	ShelfCounts   ShelfCounts `json:"shelf_counts"`
 	CreatedAt     time.Time `json:"-"`
 	Version       int32     `json:"version"`
 }
 
+// bookColumns is the column list every book query selects, in the order
+// scanFields expects.
+const bookColumns = `id, title, author, genre, page_count, average_rating,
+	want_to_read_count, currently_reading_count, read_count, created_at, version`
+
+func (book *Book) scanFields() []any {
+	return []any{
+		&book.ID,
+		&book.Title,
+		&book.Author,
+		&book.Genre,
+		&book.PageCount,
+		&book.AverageRating,
+		&book.ShelfCounts.WantToRead,
+		&book.ShelfCounts.CurrentlyReading,
+		&book.ShelfCounts.Read,
+		&book.CreatedAt,
+		&book.Version,
+	}
+}
+
 type BookModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func (m BookModel) Insert(book *Book) error {
+func (m BookModel) Insert(book *Book, event *AuditEvent) error {
 	query := `
-		INSERT INTO books (title, author, genre)
-		VALUES ($1, $2, $3)
+		INSERT INTO books (title, author, genre, page_count)
+		VALUES ($1, $2, $3, $4)
 		RETURNING id, created_at, version`
 
-	args := []interface{}{book.Title, book.Author, book.Genre}
-	return m.DB.QueryRowContext(context.Background(), query, args...).Scan(&book.ID, &book.CreatedAt, &book.Version)
+	args := []interface{}{book.Title, book.Author, book.Genre, book.PageCount}
+
+	return withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.CreatedAt, &book.Version)
+		if err != nil {
+			return err
+		}
+
+		event.ResourceID = book.ID
+		event.After, err = snapshot(book)
+		return err
+	})
 }
 
 func ValidateBook(v *validator.Validator, book *Book) {
-	v.Check(book.Title != "", "title", "must be provided")
-	v.Check(book.Author != "", "author", "must be provided")}
+	v.Struct(book)
+}
 
 func (m BookModel) Get(id int64) (*Book, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 
-	query := `
-		SELECT id, title, author, genre, average_rating, created_at, version
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM books
-		WHERE id = $1`
+		WHERE id = $1`, bookColumns)
 
 	var book Book
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
-		&book.ID,
-		&book.Title,
-		&book.Author,
-		&book.Genre,
-		&book.AverageRating,
-		&book.CreatedAt,
-		&book.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(book.scanFields()...)
 
 	if err != nil {
 		switch {
@@ -75,74 +101,118 @@ func (m BookModel) Get(id int64) (*Book, error) {
 	return &book, nil
 }
 
-func (m BookModel) Update(book *Book) error {
+// Update saves the book's editable fields. average_rating is maintained from
+// its reviews and the shelf counts from shelf_books, so they are only read
+// back here.
+func (m BookModel) Update(book *Book, event *AuditEvent) error {
 	query := `
 		UPDATE books
-		SET title = $1, author = $2, genre = $3, average_rating = $4, version = version + 1
+		SET title = $1, author = $2, genre = $3, page_count = $4, version = version + 1
 		WHERE id = $5
-		RETURNING version`
+		RETURNING average_rating, want_to_read_count, currently_reading_count, read_count, version`
 
 	args := []interface{}{
 		book.Title,
 		book.Author,
 		book.Genre,
-		book.AverageRating,
+		book.PageCount,
 		book.ID,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	return withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getBookForUpdate(ctx, tx, book.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(
+			&book.AverageRating,
+			&book.ShelfCounts.WantToRead,
+			&book.ShelfCounts.CurrentlyReading,
+			&book.ShelfCounts.Read,
+			&book.Version,
+		)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		event.ResourceID = book.ID
+		event.Before, err = snapshot(before)
+		if err != nil {
+			return err
+		}
+		event.After, err = snapshot(book)
+		return err
+	})
+}
+
+// getBookForUpdate locks the row so the audit "before" snapshot matches
+// what the update replaces.
+func getBookForUpdate(ctx context.Context, tx *sql.Tx, id int64) (*Book, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM books
+		WHERE id = $1
+		FOR UPDATE`, bookColumns)
+
+	var book Book
+
+	err := tx.QueryRowContext(ctx, query, id).Scan(book.scanFields()...)
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&book.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+			return nil, ErrRecordNotFound
 		default:
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return &book, nil
 }
 
-func (m BookModel) Delete(id int64) error {
+func (m BookModel) Delete(id int64, event *AuditEvent) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	query := `
+	query := fmt.Sprintf(`
 		DELETE FROM books
-		WHERE id = $1`
+		WHERE id = $1
+		RETURNING %s`, bookColumns)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	return withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		var book Book
 
-	result, err := m.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
+		err := tx.QueryRowContext(ctx, query, id).Scan(book.scanFields()...)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
+		event.ResourceID = book.ID
+		event.Before, err = snapshot(book)
 		return err
-	}
-
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
-	}
-
-	return nil
+	})
 }
 
 func (m BookModel) GetAll(title, author string, filters Filters) ([]*Book, Metadata, error) {
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, title, author, genre, average_rating, created_at, version
+		SELECT COUNT(*) OVER(), %s
 		FROM books
 		WHERE (title ILIKE $1 OR $1 = '')
 		AND (author ILIKE $2 OR $2 = '')
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $3 OFFSET $4`, bookColumns, filters.sortColumn(), filters.sortDirection())
 
 	args := []interface{}{
 		"%" + title + "%",
@@ -165,16 +235,7 @@ func (m BookModel) GetAll(title, author string, filters Filters) ([]*Book, Metad
 
 	for rows.Next() {
 		var book Book
-		err := rows.Scan(
-			&totalRecords,
-			&book.ID,
-			&book.Title,
-			&book.Author,
-			&book.Genre,
-			&book.AverageRating,
-			&book.CreatedAt,
-			&book.Version,
-		)
+		err := rows.Scan(append([]any{&totalRecords}, book.scanFields()...)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -188,3 +249,88 @@ func (m BookModel) GetAll(title, author string, filters Filters) ([]*Book, Metad
 	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
 	return books, metadata, nil
 }
+
+const bookImportBatchSize = 1000
+
+// Import bulk-loads books with COPY, flushing every bookImportBatchSize rows.
+// next returns the next book to insert, or nil once the source is exhausted.
+// The whole import runs in one transaction, so a failure leaves no rows
+// behind.
+func (m BookModel) Import(event *AuditEvent, next func() (*Book, error)) (int, error) {
+	imported := 0
+
+	err := withAuditTimeout(m.DB, 5*time.Minute, event, func(ctx context.Context, tx *sql.Tx) error {
+		for done := false; !done; {
+			stmt, err := tx.PrepareContext(ctx, pq.CopyIn("books", "title", "author", "genre", "page_count"))
+			if err != nil {
+				return err
+			}
+
+			batch := 0
+			for batch < bookImportBatchSize {
+				book, err := next()
+				if err != nil {
+					stmt.Close()
+					return err
+				}
+				if book == nil {
+					done = true
+					break
+				}
+
+				_, err = stmt.ExecContext(ctx, book.Title, book.Author, book.Genre, book.PageCount)
+				if err != nil {
+					stmt.Close()
+					return err
+				}
+				batch++
+			}
+
+			_, err = stmt.ExecContext(ctx)
+			if err != nil {
+				stmt.Close()
+				return err
+			}
+
+			err = stmt.Close()
+			if err != nil {
+				return err
+			}
+			imported += batch
+		}
+
+		var err error
+		event.After, err = snapshot(map[string]int{"imported": imported})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return imported, nil
+}
+
+// Export streams every book matching the list filters to fn, in the order
+// given by filters.Sort. Paging fields on filters are ignored.
+func (m BookModel) Export(title, author string, filters Filters, fn func(*Book) error) error {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM books
+		WHERE (title ILIKE $1 OR $1 = '')
+		AND (author ILIKE $2 OR $2 = '')
+		ORDER BY %s %s, id ASC`, bookColumns, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{
+		"%" + title + "%",
+		"%" + author + "%",
+	}
+
+	return streamRows(m.DB, query, args, func(rows *sql.Rows) error {
+		var book Book
+		err := rows.Scan(book.scanFields()...)
+		if err != nil {
+			return err
+		}
+		return fn(&book)
+	})
+}
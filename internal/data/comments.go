@@ -0,0 +1,355 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tchenbz/AWTtest3/internal/policy"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// MaxCommentDepth bounds reply threading: top-level comments have depth 0
+// and a reply is one deeper than its parent.
+const MaxCommentDepth = 4
+
+// Comment is a reply to a review or to another comment on it. Deleted
+// comments keep their place in the thread with their content removed.
+type Comment struct {
+	ID              int64      `json:"id"`
+	ReviewID        int64      `json:"review_id"`
+	ParentID        int64      `json:"parent_id,omitempty"`
+	UserID          int64      `json:"user_id"`
+	Content         string     `json:"content"`
+	Depth           int        `json:"depth"`
+	Status          string     `json:"status"`
+	RejectionReason string     `json:"rejection_reason,omitempty"`
+	ModeratedBy     int64      `json:"moderated_by,omitempty"`
+	ModeratedAt     *time.Time `json:"moderated_at,omitempty"`
+	Deleted         bool       `json:"deleted,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	Version         int32      `json:"version"`
+}
+
+// commentColumns matches the order of Comment.scanFields.
+const commentColumns = `id, review_id, COALESCE(parent_id, 0), user_id, content, depth, status,
+		rejection_reason, COALESCE(moderated_by, 0), moderated_at, deleted_at IS NOT NULL, created_at, version`
+
+func (comment *Comment) scanFields() []any {
+	return []any{
+		&comment.ID, &comment.ReviewID, &comment.ParentID, &comment.UserID, &comment.Content,
+		&comment.Depth, &comment.Status, &comment.RejectionReason, &comment.ModeratedBy,
+		&comment.ModeratedAt, &comment.Deleted, &comment.CreatedAt, &comment.Version,
+	}
+}
+
+// ValidateComment applies the review content policy p to the comment, and
+// checks that a reply stays within MaxCommentDepth.
+func ValidateComment(v *validator.Validator, comment *Comment, p *policy.Review) {
+	p.CheckContent(v, "content", comment.Content)
+	v.Check(comment.Depth <= MaxCommentDepth, "parent_id", "max_depth", "max", MaxCommentDepth)
+}
+
+type CommentModel struct {
+	DB DBTX
+}
+
+// refreshCommentCount recounts the visible comments on a review. It runs
+// after every change that can alter the count.
+func refreshCommentCount(ctx context.Context, tx *sql.Tx, reviewID int64) error {
+	query := `
+		UPDATE reviews
+		SET comment_count = (
+			SELECT COUNT(*) FROM comments
+			WHERE review_id = $1 AND status = 'approved' AND deleted_at IS NULL
+		)
+		WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, reviewID)
+	return err
+}
+
+func (m CommentModel) Insert(comment *Comment, event *AuditEvent) error {
+	query := `
+		INSERT INTO comments (review_id, parent_id, user_id, content, depth, status)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{comment.ReviewID, comment.ParentID, comment.UserID, comment.Content, comment.Depth, comment.Status}
+
+	return withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&comment.ID, &comment.CreatedAt, &comment.Version)
+		if err != nil {
+			return err
+		}
+
+		err = refreshCommentCount(ctx, tx, comment.ReviewID)
+		if err != nil {
+			return err
+		}
+
+		err = notifyComment(ctx, tx, comment)
+		if err != nil {
+			return err
+		}
+
+		event.ResourceID = comment.ID
+		event.After, err = snapshot(comment)
+		return err
+	})
+}
+
+func (m CommentModel) Get(reviewID, commentID int64) (*Comment, error) {
+	if reviewID < 1 || commentID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + commentColumns + `
+		FROM comments
+		WHERE review_id = $1 AND id = $2`
+
+	var comment Comment
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, reviewID, commentID).Scan(comment.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &comment, nil
+}
+
+func getCommentForUpdate(ctx context.Context, tx *sql.Tx, commentID int64) (*Comment, error) {
+	query := `
+		SELECT ` + commentColumns + `
+		FROM comments
+		WHERE id = $1
+		FOR UPDATE`
+
+	var comment Comment
+
+	err := tx.QueryRowContext(ctx, query, commentID).Scan(comment.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &comment, nil
+}
+
+// Update saves the comment's content and status. It returns
+// ErrRecordNotFound if the comment has been deleted.
+func (m CommentModel) Update(comment *Comment, event *AuditEvent) error {
+	query := `
+		UPDATE comments
+		SET content = $1, status = $2, rejection_reason = $3, version = version + 1
+		WHERE id = $4 AND deleted_at IS NULL
+		RETURNING version`
+
+	args := []interface{}{comment.Content, comment.Status, comment.RejectionReason, comment.ID}
+
+	return withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getCommentForUpdate(ctx, tx, comment.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&comment.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		err = refreshCommentCount(ctx, tx, comment.ReviewID)
+		if err != nil {
+			return err
+		}
+
+		event.ResourceID = comment.ID
+		event.Before, err = snapshot(before)
+		if err != nil {
+			return err
+		}
+		event.After, err = snapshot(comment)
+		return err
+	})
+}
+
+// Delete removes a comment's content but keeps the row, so replies to it
+// stay in their thread.
+func (m CommentModel) Delete(reviewID, commentID int64, event *AuditEvent) error {
+	if reviewID < 1 || commentID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE comments
+		SET content = '', deleted_at = NOW(), version = version + 1
+		WHERE review_id = $1 AND id = $2 AND deleted_at IS NULL`
+
+	return withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getCommentForUpdate(ctx, tx, commentID)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, query, reviewID, commentID)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrRecordNotFound
+		}
+
+		err = refreshCommentCount(ctx, tx, reviewID)
+		if err != nil {
+			return err
+		}
+
+		event.ResourceID = commentID
+		event.Before, err = snapshot(before)
+		return err
+	})
+}
+
+// Moderate sets a comment's status on behalf of moderatorID, like
+// ReviewModel.Moderate.
+func (m CommentModel) Moderate(commentID int64, status, reason string, moderatorID int64, event *AuditEvent) (*Comment, error) {
+	if commentID < 1 {
+		return nil, ErrRecordNotFound
+	}
+	if status != ReviewStatusRejected {
+		reason = ""
+	}
+
+	query := `
+		UPDATE comments
+		SET status = $1, rejection_reason = $2, moderated_by = NULLIF($3, 0), moderated_at = NOW(),
+			version = version + 1
+		WHERE id = $4
+		RETURNING ` + commentColumns
+
+	var comment Comment
+
+	err := withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getCommentForUpdate(ctx, tx, commentID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, query, status, reason, moderatorID, commentID).Scan(comment.scanFields()...)
+		if err != nil {
+			return err
+		}
+
+		err = refreshCommentCount(ctx, tx, comment.ReviewID)
+		if err != nil {
+			return err
+		}
+
+		if before.Status != ReviewStatusApproved {
+			err = notifyComment(ctx, tx, &comment)
+			if err != nil {
+				return err
+			}
+		}
+
+		event.ResourceID = comment.ID
+		event.Before, err = snapshot(before)
+		if err != nil {
+			return err
+		}
+		event.After, err = snapshot(comment)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &comment, nil
+}
+
+// GetAllForReview pages through a review's comments oldest first. With a
+// parentID only the direct replies to that comment are listed. Like reviews,
+// unapproved comments are only listed for their author.
+func (m CommentModel) GetAllForReview(reviewID, parentID, viewerID int64, filters CursorFilters) ([]*Comment, CursorMetadata, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM comments
+		WHERE review_id = $1
+		AND (parent_id = $2 OR $2 = 0)
+		AND (status = 'approved' OR ($3 <> 0 AND user_id = $3))
+		AND id > $4
+		ORDER BY id ASC
+		LIMIT $5`, commentColumns)
+
+	args := []interface{}{reviewID, parentID, viewerID, filters.after(), filters.Limit + 1}
+
+	return m.listCursor(query, args, filters)
+}
+
+// GetAllByStatus is the comment moderation queue, oldest first.
+func (m CommentModel) GetAllByStatus(status string, filters CursorFilters) ([]*Comment, CursorMetadata, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM comments
+		WHERE status = $1 AND deleted_at IS NULL
+		AND id > $2
+		ORDER BY id ASC
+		LIMIT $3`, commentColumns)
+
+	args := []interface{}{status, filters.after(), filters.Limit + 1}
+
+	return m.listCursor(query, args, filters)
+}
+
+func (m CommentModel) listCursor(query string, args []any, filters CursorFilters) ([]*Comment, CursorMetadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, CursorMetadata{}, err
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(comment.scanFields()...)
+		if err != nil {
+			return nil, CursorMetadata{}, err
+		}
+		comments = append(comments, &comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, CursorMetadata{}, err
+	}
+
+	metadata, n := cursorMetadata(filters, len(comments), func(i int) int64 { return comments[i].ID })
+	return comments[:n], metadata, nil
+}
@@ -0,0 +1,60 @@
+package data
+
+import (
+	"encoding/base64"
+	"strconv"
+
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// CursorFilters pages through a list by position instead of page number, so
+// rows added while a client is paging don't shift what it sees. Cursor is
+// the opaque next_cursor from the previous page, or empty for the first.
+type CursorFilters struct {
+	Cursor string
+	Limit  int
+}
+
+type CursorMetadata struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	Limit      int    `json:"limit"`
+}
+
+func ValidateCursorFilters(v *validator.Validator, f CursorFilters) {
+	_, err := decodeCursor(f.Cursor)
+	v.Check(err == nil, "cursor", "invalid_cursor")
+	v.Check(f.Limit > 0, "limit", "positive")
+	v.Check(f.Limit <= 100, "limit", "max_value", "max", 100)
+}
+
+// after is the ID the next page starts after.
+func (f CursorFilters) after() int64 {
+	id, _ := decodeCursor(f.Cursor)
+	return id
+}
+
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(b), 10, 64)
+}
+
+// cursorMetadata builds the metadata for a page fetched with one row more
+// than the limit, and reports how many rows to keep.
+func cursorMetadata(f CursorFilters, fetched int, lastID func(i int) int64) (CursorMetadata, int) {
+	metadata := CursorMetadata{Limit: f.Limit}
+	if fetched <= f.Limit {
+		return metadata, fetched
+	}
+	metadata.NextCursor = encodeCursor(lastID(f.Limit - 1))
+	return metadata, f.Limit
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DBTX is satisfied by both *sql.DB and *sql.Tx, so a model can be pointed
+// at a transaction owned by its caller.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// txScope is a transaction that may belong to the caller. When it does,
+// the scope is a savepoint inside it, so a failed statement such as a
+// unique violation only undoes the scope's own work and the caller's
+// transaction stays usable.
+type txScope struct {
+	ctx   context.Context
+	tx    *sql.Tx
+	owned bool
+	done  bool
+}
+
+// begin starts a transaction on db, or a savepoint in the caller's
+// transaction when db is already a *sql.Tx. Scopes on one transaction must
+// end in the reverse order they began, as savepoints of the same name
+// unwind to the most recent one.
+func begin(ctx context.Context, db DBTX, opts *sql.TxOptions) (*txScope, error) {
+	if tx, ok := db.(*sql.Tx); ok {
+		_, err := tx.ExecContext(ctx, "SAVEPOINT scope")
+		if err != nil {
+			return nil, err
+		}
+		return &txScope{ctx: ctx, tx: tx}, nil
+	}
+
+	tx, err := db.(*sql.DB).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &txScope{ctx: ctx, tx: tx, owned: true}, nil
+}
+
+func (s *txScope) commit() error {
+	s.done = true
+	if s.owned {
+		return s.tx.Commit()
+	}
+	_, err := s.tx.ExecContext(s.ctx, "RELEASE SAVEPOINT scope")
+	return err
+}
+
+// rollback undoes the scope unless it has been committed, so it can be
+// deferred straight after begin.
+func (s *txScope) rollback() {
+	if s.done {
+		return
+	}
+	s.done = true
+	if s.owned {
+		s.tx.Rollback()
+		return
+	}
+	// The scope's context may already be done; the savepoint must still go.
+	ctx := context.WithoutCancel(s.ctx)
+	s.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT scope")
+	s.tx.ExecContext(ctx, "RELEASE SAVEPOINT scope")
+}
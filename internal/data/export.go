@@ -0,0 +1,63 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const exportFetchSize = 500
+
+// streamRows runs query through a server-side cursor and calls scan for each
+// row, fetching exportFetchSize rows at a time so memory use stays flat no
+// matter how large the result is. Returning an error from scan stops the
+// stream.
+func streamRows(db DBTX, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	scope, err := begin(ctx, db, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer scope.rollback()
+	tx := scope.tx
+
+	_, err = tx.ExecContext(ctx, "DECLARE export_cursor NO SCROLL CURSOR FOR "+query, args...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH FORWARD %d FROM export_cursor", exportFetchSize))
+		if err != nil {
+			return err
+		}
+
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			err = scan(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		if fetched < exportFetchSize {
+			break
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "CLOSE export_cursor")
+	if err != nil {
+		return err
+	}
+
+	return scope.commit()
+}
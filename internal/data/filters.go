@@ -22,11 +22,11 @@ type Metadata struct {
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 500, "page", "must not exceed 500")
-	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+	v.Check(f.Page > 0, "page", "positive")
+	v.Check(f.Page <= 500, "page", "max_value", "max", 500)
+	v.Check(f.PageSize > 0, "page_size", "positive")
+	v.Check(f.PageSize <= 100, "page_size", "max_value", "max", 100)
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafeList...), "sort", "invalid_sort")
 }
 
 func (f Filters) limit() int {
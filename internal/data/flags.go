@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+var ErrDuplicateFlag = errors.New("review already flagged by this user")
+
+const (
+	FlagReasonSpam      = "spam"
+	FlagReasonOffensive = "offensive"
+	FlagReasonSpoiler   = "spoiler"
+)
+
+// Flag is one reader's report against a review. A user can flag a review
+// only once.
+type Flag struct {
+	ID        int64     `json:"id"`
+	ReviewID  int64     `json:"review_id"`
+	UserID    int64     `json:"user_id"`
+	Reason    string    `json:"reason" validate:"required,one_of=spam offensive spoiler"`
+	Comment   string    `json:"comment,omitempty" validate:"max_len=500"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ValidateFlag(v *validator.Validator, flag *Flag) {
+	v.Struct(flag)
+}
+
+// FlaggedReview is a review in the moderators' flag queue. Severity is the
+// weighted sum of its unresolved flags.
+type FlaggedReview struct {
+	Review        *Review        `json:"review"`
+	FlagCount     int            `json:"flag_count"`
+	Severity      float64        `json:"severity"`
+	Reasons       map[string]int `json:"reasons"`
+	LastFlaggedAt time.Time      `json:"last_flagged_at"`
+}
+
+type FlagModel struct {
+	DB DBTX
+}
+
+// weightArrays splits weights into parallel arrays for unnest().
+func weightArrays(weights map[string]float64) (interface{}, interface{}) {
+	reasons := make([]string, 0, len(weights))
+	values := make([]float64, 0, len(weights))
+	for reason, weight := range weights {
+		reasons = append(reasons, reason)
+		values = append(values, weight)
+	}
+	return pq.Array(reasons), pq.Array(values)
+}
+
+// Insert records flag and hides the review if the weighted total of its
+// unresolved flags reaches threshold. It reports whether the review is hidden
+// afterwards, and returns ErrDuplicateFlag if the user has already flagged it.
+func (m FlagModel) Insert(flag *Flag, weights map[string]float64, threshold float64, event *AuditEvent) (bool, error) {
+	insertQuery := `
+		INSERT INTO review_flags (review_id, user_id, reason, comment)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (review_id, user_id) DO NOTHING
+		RETURNING id, created_at`
+
+	scoreQuery := `
+		SELECT COALESCE(SUM(w.weight), 0)
+		FROM review_flags f
+		JOIN unnest($2::text[], $3::float8[]) AS w (reason, weight) ON w.reason = f.reason
+		WHERE f.review_id = $1 AND f.resolved_at IS NULL`
+
+	hideQuery := `
+		UPDATE reviews
+		SET hidden = true
+		WHERE id = $1
+		RETURNING book_id`
+
+	reasons, values := weightArrays(weights)
+
+	var hidden bool
+
+	err := withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, insertQuery, flag.ReviewID, flag.UserID, flag.Reason, flag.Comment).Scan(&flag.ID, &flag.CreatedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrDuplicateFlag
+			default:
+				return err
+			}
+		}
+
+		var score float64
+		err = tx.QueryRowContext(ctx, scoreQuery, flag.ReviewID, reasons, values).Scan(&score)
+		if err != nil {
+			return err
+		}
+
+		if score >= threshold {
+			var bookID int64
+			err = tx.QueryRowContext(ctx, hideQuery, flag.ReviewID).Scan(&bookID)
+			if err != nil {
+				return err
+			}
+			hidden = true
+
+			err = refreshBookRating(ctx, tx, bookID)
+			if err != nil {
+				return err
+			}
+		}
+
+		event.ResourceID = flag.ID
+		event.After, err = snapshot(flag)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return hidden, nil
+}
+
+// GetFlaggedReviews lists reviews with unresolved flags, scoring each one
+// with the given reason weights.
+func (m FlagModel) GetFlaggedReviews(weights map[string]float64, filters Filters) ([]*FlaggedReview, Metadata, error) {
+	query := fmt.Sprintf(`
+		WITH weights (reason, weight) AS (
+			SELECT * FROM unnest($1::text[], $2::float8[])
+		), counts AS (
+			SELECT review_id, reason, COUNT(*) AS n, MAX(created_at) AS last_flagged_at
+			FROM review_flags
+			WHERE resolved_at IS NULL
+			GROUP BY review_id, reason
+		), flagged AS (
+			SELECT c.review_id, SUM(c.n) AS flag_count, SUM(c.n * COALESCE(w.weight, 0)) AS severity,
+				jsonb_object_agg(c.reason, c.n) AS reasons, MAX(c.last_flagged_at) AS last_flagged_at
+			FROM counts c
+			LEFT JOIN weights w ON w.reason = c.reason
+			GROUP BY c.review_id
+		)
+		SELECT COUNT(*) OVER(), %s, flagged.flag_count, flagged.severity, flagged.reasons, flagged.last_flagged_at
+		FROM flagged
+		JOIN reviews ON reviews.id = flagged.review_id
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, reviewColumns, filters.sortColumn(), filters.sortDirection())
+
+	reasons, values := weightArrays(weights)
+	args := []interface{}{reasons, values, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	flagged := []*FlaggedReview{}
+
+	for rows.Next() {
+		item := FlaggedReview{Review: &Review{}}
+		var reasonsJSON []byte
+
+		dest := append([]any{&totalRecords}, item.Review.scanFields()...)
+		dest = append(dest, &item.FlagCount, &item.Severity, &reasonsJSON, &item.LastFlaggedAt)
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(reasonsJSON, &item.Reasons)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		flagged = append(flagged, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return flagged, metadata, nil
+}
@@ -0,0 +1,170 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Follow is one side of a follow relationship: the other user and when the
+// follow started.
+type Follow struct {
+	UserID     int64     `json:"user_id"`
+	FollowedAt time.Time `json:"followed_at"`
+}
+
+type FollowModel struct {
+	DB DBTX
+}
+
+// Insert makes followerID follow followeeID and copies the followee's
+// recent activity into the follower's timeline. Following someone twice is
+// harmless.
+func (m FollowModel) Insert(followerID, followeeID int64) (*Follow, error) {
+	insertQuery := `
+		INSERT INTO follows (follower_id, followee_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	selectQuery := `
+		SELECT followee_id, created_at
+		FROM follows
+		WHERE follower_id = $1 AND followee_id = $2`
+
+	backfillQuery := `
+		INSERT INTO timelines (user_id, activity_id, actor_id)
+		SELECT $1, id, actor_id
+		FROM (
+			SELECT id, actor_id FROM activities
+			WHERE actor_id = $2
+			ORDER BY id DESC
+			LIMIT $3
+		) AS recent
+		ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scope, err := begin(ctx, m.DB, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer scope.rollback()
+
+	result, err := scope.tx.ExecContext(ctx, insertQuery, followerID, followeeID)
+	if err != nil {
+		return nil, err
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if added > 0 {
+		_, err = scope.tx.ExecContext(ctx, backfillQuery, followerID, followeeID, feedBackfill)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var follow Follow
+	err = scope.tx.QueryRowContext(ctx, selectQuery, followerID, followeeID).Scan(&follow.UserID, &follow.FollowedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	err = scope.commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &follow, nil
+}
+
+// Delete ends a follow and removes the followee's activity from the
+// follower's timeline. It returns ErrRecordNotFound if there was no follow.
+func (m FollowModel) Delete(followerID, followeeID int64) error {
+	deleteQuery := `
+		DELETE FROM follows
+		WHERE follower_id = $1 AND followee_id = $2`
+
+	timelineQuery := `
+		DELETE FROM timelines
+		WHERE user_id = $1 AND actor_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scope, err := begin(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer scope.rollback()
+
+	result, err := scope.tx.ExecContext(ctx, deleteQuery, followerID, followeeID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	_, err = scope.tx.ExecContext(ctx, timelineQuery, followerID, followeeID)
+	if err != nil {
+		return err
+	}
+
+	return scope.commit()
+}
+
+// GetFollowers lists the users who follow userID.
+func (m FollowModel) GetFollowers(userID int64, filters Filters) ([]*Follow, Metadata, error) {
+	return m.list("follower_id", "followee_id", userID, filters)
+}
+
+// GetFollowing lists the users userID follows.
+func (m FollowModel) GetFollowing(userID int64, filters Filters) ([]*Follow, Metadata, error) {
+	return m.list("followee_id", "follower_id", userID, filters)
+}
+
+// list selects the other column of the follows matching userID in column.
+func (m FollowModel) list(other, column string, userID int64, filters Filters) ([]*Follow, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %[1]s AS user_id, created_at
+		FROM follows
+		WHERE %[2]s = $1
+		ORDER BY %[3]s %[4]s, %[1]s ASC
+		LIMIT $2 OFFSET $3`, other, column, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	follows := []*Follow{}
+
+	for rows.Next() {
+		var follow Follow
+		err := rows.Scan(&totalRecords, &follow.UserID, &follow.FollowedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		follows = append(follows, &follow)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return follows, metadata, nil
+}
@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// IdempotencyRecord is the stored outcome of the first request made with an
+// Idempotency-Key. Status is zero while that request is still running.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+type IdempotencyModel struct {
+	DB *sql.DB
+}
+
+// Claim reserves key for userID. It returns nil if the key was free (or its
+// previous use had expired) and the caller now owns it; otherwise it returns
+// the existing record.
+func (m IdempotencyModel) Claim(userID int64, key, fingerprint string, ttl time.Duration) (*IdempotencyRecord, error) {
+	insertQuery := `
+		INSERT INTO idempotency_keys (user_id, key, fingerprint, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second')
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET fingerprint = EXCLUDED.fingerprint, status = NULL, header = NULL, body = NULL,
+		    created_at = NOW(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+		RETURNING user_id`
+
+	selectQuery := `
+		SELECT fingerprint, COALESCE(status, 0), COALESCE(header, '{}'), COALESCE(body, '')
+		FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The key can be released or purged between the two queries, in which
+	// case it is free again and the insert is retried.
+	for attempt := 0; attempt < 3; attempt++ {
+		var owner int64
+		err := m.DB.QueryRowContext(ctx, insertQuery, userID, key, fingerprint, ttl.Seconds()).Scan(&owner)
+		if err == nil {
+			return nil, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		var record IdempotencyRecord
+		var header []byte
+
+		err = m.DB.QueryRowContext(ctx, selectQuery, userID, key).Scan(&record.Fingerprint, &record.Status, &header, &record.Body)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(header, &record.Header)
+		if err != nil {
+			return nil, err
+		}
+
+		return &record, nil
+	}
+
+	// Other requests keep taking the key; report it as in progress.
+	return &IdempotencyRecord{Fingerprint: fingerprint}, nil
+}
+
+// Complete stores the response so later retries can replay it.
+func (m IdempotencyModel) Complete(userID int64, key string, status int, header http.Header, body []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status = $3, header = $4, body = $5
+		WHERE user_id = $1 AND key = $2`
+
+	js, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, userID, key, status, js, body)
+	return err
+}
+
+// Release forgets a claimed key whose request failed, so it can be retried.
+func (m IdempotencyModel) Release(userID int64, key string) error {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key)
+	return err
+}
+
+func (m IdempotencyModel) DeleteExpired() (int64, error) {
+	query := `
+		DELETE FROM idempotency_keys
+		WHERE expires_at < NOW()`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,301 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	NotificationComment  = "comment"
+	NotificationReply    = "reply"
+	NotificationReaction = "reaction"
+	NotificationHelpful  = "helpful"
+)
+
+// NotificationTypes are the kinds of notification a user can switch on and
+// off. All are on by default.
+var NotificationTypes = []string{NotificationComment, NotificationReply, NotificationReaction, NotificationHelpful}
+
+// Notification tells a user that others commented on or reacted to their
+// review, found it helpful, or replied to their comment. Events about the
+// same review or comment are coalesced into one unread notification, with
+// every actor listed once.
+type Notification struct {
+	ID         int64      `json:"id"`
+	Type       string     `json:"type"`
+	Message    string     `json:"message"`
+	BookID     int64      `json:"book_id"`
+	ReviewID   int64      `json:"review_id"`
+	CommentID  int64      `json:"comment_id,omitempty"`
+	ActorIDs   []int64    `json:"actor_ids"`
+	ActorCount int        `json:"actor_count"`
+	Read       bool       `json:"read"`
+	ReadAt     *time.Time `json:"read_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// notificationColumns matches the order of Notification.scanFields.
+const notificationColumns = `id, type, book_id, review_id, comment_id, actor_ids, read_at, created_at, updated_at`
+
+func (n *Notification) scanFields() []any {
+	return []any{
+		&n.ID, &n.Type, &n.BookID, &n.ReviewID, &n.CommentID, (*pq.Int64Array)(&n.ActorIDs),
+		&n.ReadAt, &n.CreatedAt, &n.UpdatedAt,
+	}
+}
+
+// scanned fills in the fields derived from the stored ones.
+func (n *Notification) scanned() {
+	n.ActorCount = len(n.ActorIDs)
+	n.Read = n.ReadAt != nil
+}
+
+// notify records that actorID did something of kind notificationType to
+// userID's review (and comment, if commentID isn't 0). Nothing is recorded
+// for a user's own actions, for anonymous users, or when the user has
+// switched the type off.
+func notify(ctx context.Context, tx *sql.Tx, userID int64, notificationType string, bookID, reviewID, commentID, actorID int64) error {
+	query := `
+		INSERT INTO notifications (user_id, type, book_id, review_id, comment_id, actor_ids)
+		SELECT $1, $2, $3, $4, $5, ARRAY[$6::bigint]
+		WHERE NOT EXISTS (
+			SELECT 1 FROM notification_preferences
+			WHERE user_id = $1 AND type = $2 AND NOT enabled
+		)
+		ON CONFLICT (user_id, type, review_id, comment_id) WHERE read_at IS NULL DO UPDATE
+		SET actor_ids = CASE
+				WHEN $6::bigint = ANY(notifications.actor_ids) THEN notifications.actor_ids
+				ELSE array_append(notifications.actor_ids, $6::bigint)
+			END,
+			updated_at = NOW()`
+
+	if userID == 0 || actorID == 0 || userID == actorID {
+		return nil
+	}
+
+	_, err := tx.ExecContext(ctx, query, userID, notificationType, bookID, reviewID, commentID, actorID)
+	return err
+}
+
+// notifyComment tells the review's author about a newly visible comment,
+// and for a reply, the author of the comment replied to.
+func notifyComment(ctx context.Context, tx *sql.Tx, comment *Comment) error {
+	reviewQuery := `
+		SELECT book_id, COALESCE(user_id, 0)
+		FROM reviews
+		WHERE id = $1`
+
+	parentQuery := `
+		SELECT user_id
+		FROM comments
+		WHERE id = $1 AND deleted_at IS NULL`
+
+	if comment.Status != ReviewStatusApproved {
+		return nil
+	}
+
+	var bookID, reviewerID int64
+	err := tx.QueryRowContext(ctx, reviewQuery, comment.ReviewID).Scan(&bookID, &reviewerID)
+	if err != nil {
+		return err
+	}
+
+	err = notify(ctx, tx, reviewerID, NotificationComment, bookID, comment.ReviewID, 0, comment.UserID)
+	if err != nil || comment.ParentID == 0 {
+		return err
+	}
+
+	var parentAuthorID int64
+	err = tx.QueryRowContext(ctx, parentQuery, comment.ParentID).Scan(&parentAuthorID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	// The review's author has just been told about this comment.
+	if parentAuthorID == reviewerID {
+		return nil
+	}
+	return notify(ctx, tx, parentAuthorID, NotificationReply, bookID, comment.ReviewID, comment.ParentID, comment.UserID)
+}
+
+type NotificationModel struct {
+	DB DBTX
+}
+
+// GetAll lists a user's notifications, most recently updated first, and
+// counts their unread ones.
+func (m NotificationModel) GetAll(userID int64, unreadOnly bool, filters Filters) ([]*Notification, Metadata, int, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM notifications
+		WHERE user_id = $1
+		AND (read_at IS NULL OR NOT $2)
+		ORDER BY %s %s, id DESC
+		LIMIT $3 OFFSET $4`, notificationColumns, filters.sortColumn(), filters.sortDirection())
+
+	unreadQuery := `
+		SELECT COUNT(*)
+		FROM notifications
+		WHERE user_id = $1 AND read_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var unread int
+	err := m.DB.QueryRowContext(ctx, unreadQuery, userID).Scan(&unread)
+	if err != nil {
+		return nil, Metadata{}, 0, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, unreadOnly, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, 0, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	notifications := []*Notification{}
+
+	for rows.Next() {
+		var notification Notification
+		err := rows.Scan(append([]any{&totalRecords}, notification.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, 0, err
+		}
+		notification.scanned()
+		notifications = append(notifications, &notification)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, 0, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return notifications, metadata, unread, nil
+}
+
+// MarkRead marks one of the user's notifications read and returns it.
+// Marking it again keeps the original read time.
+func (m NotificationModel) MarkRead(userID, notificationID int64) (*Notification, error) {
+	if userID < 1 || notificationID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		UPDATE notifications
+		SET read_at = COALESCE(read_at, NOW())
+		WHERE user_id = $1 AND id = $2
+		RETURNING ` + notificationColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var notification Notification
+
+	err := m.DB.QueryRowContext(ctx, query, userID, notificationID).Scan(notification.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	notification.scanned()
+
+	return &notification, nil
+}
+
+// MarkAllRead marks every unread notification of the user read and returns
+// how many there were.
+func (m NotificationModel) MarkAllRead(userID int64) (int64, error) {
+	query := `
+		UPDATE notifications
+		SET read_at = NOW()
+		WHERE user_id = $1 AND read_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetPreferences returns whether each notification type is on for the user.
+func (m NotificationModel) GetPreferences(userID int64) (map[string]bool, error) {
+	query := `
+		SELECT type, enabled
+		FROM notification_preferences
+		WHERE user_id = $1`
+
+	preferences := make(map[string]bool, len(NotificationTypes))
+	for _, notificationType := range NotificationTypes {
+		preferences[notificationType] = true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var notificationType string
+		var enabled bool
+		err := rows.Scan(&notificationType, &enabled)
+		if err != nil {
+			return nil, err
+		}
+		preferences[notificationType] = enabled
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return preferences, nil
+}
+
+// SetPreferences switches the given notification types on or off, leaving
+// the others as they are.
+func (m NotificationModel) SetPreferences(userID int64, preferences map[string]bool) error {
+	query := `
+		INSERT INTO notification_preferences (user_id, type, enabled)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, type) DO UPDATE
+		SET enabled = EXCLUDED.enabled`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scope, err := begin(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer scope.rollback()
+
+	for notificationType, enabled := range preferences {
+		_, err = scope.tx.ExecContext(ctx, query, userID, notificationType, enabled)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scope.commit()
+}
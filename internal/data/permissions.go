@@ -0,0 +1,12 @@
+package data
+
+import (
+	"slices"
+)
+
+// Permissions holds permission codes such as "audit:read".
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
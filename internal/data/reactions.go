@@ -0,0 +1,204 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ReactionTypes are the reactions a user can leave on a review, alongside
+// the helpful count.
+var ReactionTypes = []string{"like", "funny", "insightful", "sad"}
+
+// ReactionCounts is the per-type tally stored on each review, so listing
+// reviews needs no extra query. Every type is present, zero or not.
+type ReactionCounts map[string]int
+
+func (c *ReactionCounts) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return fmt.Errorf("cannot scan %T into ReactionCounts", src)
+	}
+
+	counts := make(ReactionCounts, len(ReactionTypes))
+	for _, reaction := range ReactionTypes {
+		counts[reaction] = 0
+	}
+	err := json.Unmarshal(b, (*map[string]int)(&counts))
+	if err != nil {
+		return err
+	}
+
+	*c = counts
+	return nil
+}
+
+type ReactionModel struct {
+	DB DBTX
+}
+
+// Set adds (add true) or removes one user's reaction to a review and returns
+// the review's updated counts. A new reaction notifies the review's author.
+// Repeating a call is harmless. It returns ErrRecordNotFound if the review
+// doesn't exist.
+func (m ReactionModel) Set(reviewID, userID int64, reaction string, add bool) (ReactionCounts, error) {
+	insertQuery := `
+		INSERT INTO review_reactions (review_id, user_id, reaction)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+
+	deleteQuery := `
+		DELETE FROM review_reactions
+		WHERE review_id = $1 AND user_id = $2 AND reaction = $3`
+
+	// The adjustment is applied to the stored value in one statement, so
+	// concurrent reactions can't overwrite each other's counts.
+	countQuery := `
+		UPDATE reviews
+		SET reaction_counts = jsonb_set(reaction_counts, ARRAY[$2::text],
+			to_jsonb(GREATEST(COALESCE((reaction_counts ->> $2::text)::integer, 0) + $3, 0)))
+		WHERE id = $1
+		RETURNING reaction_counts, book_id, COALESCE(user_id, 0)`
+
+	selectQuery := `
+		SELECT reaction_counts
+		FROM reviews
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scope, err := begin(ctx, m.DB, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer scope.rollback()
+
+	query, delta := insertQuery, 1
+	if !add {
+		query, delta = deleteQuery, -1
+	}
+
+	var counts ReactionCounts
+	var bookID, reviewerID int64
+
+	result, err := scope.tx.ExecContext(ctx, query, reviewID, userID, reaction)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if changed > 0 {
+		err = scope.tx.QueryRowContext(ctx, countQuery, reviewID, reaction, delta).Scan(&counts, &bookID, &reviewerID)
+	} else {
+		err = scope.tx.QueryRowContext(ctx, selectQuery, reviewID).Scan(&counts)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if changed > 0 && add {
+		err = notify(ctx, scope.tx, reviewerID, NotificationReaction, bookID, reviewID, 0, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = scope.commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// SetHelpful adds (add true) or removes one user's helpful vote on a review
+// and returns the review's updated helpful count. A new vote notifies the
+// review's author. Repeating a call is harmless. It returns
+// ErrRecordNotFound if the review doesn't exist.
+func (m ReactionModel) SetHelpful(reviewID, userID int64, add bool) (int, error) {
+	insertQuery := `
+		INSERT INTO review_helpful_votes (review_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`
+
+	deleteQuery := `
+		DELETE FROM review_helpful_votes
+		WHERE review_id = $1 AND user_id = $2`
+
+	countQuery := `
+		UPDATE reviews
+		SET helpful_count = GREATEST(helpful_count + $2, 0)
+		WHERE id = $1
+		RETURNING helpful_count, book_id, COALESCE(user_id, 0)`
+
+	selectQuery := `
+		SELECT helpful_count
+		FROM reviews
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scope, err := begin(ctx, m.DB, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer scope.rollback()
+
+	query, delta := insertQuery, 1
+	if !add {
+		query, delta = deleteQuery, -1
+	}
+
+	var count int
+	var bookID, reviewerID int64
+
+	result, err := scope.tx.ExecContext(ctx, query, reviewID, userID)
+	if err != nil {
+		return 0, err
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if changed > 0 {
+		err = scope.tx.QueryRowContext(ctx, countQuery, reviewID, delta).Scan(&count, &bookID, &reviewerID)
+	} else {
+		err = scope.tx.QueryRowContext(ctx, selectQuery, reviewID).Scan(&count)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	if changed > 0 && add {
+		err = notify(ctx, scope.tx, reviewerID, NotificationHelpful, bookID, reviewID, 0, userID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = scope.commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,347 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// ErrActiveSession is returned when a user starts a book they are already
+// reading.
+var ErrActiveSession = errors.New("reading session already in progress")
+
+// ReadingSession is one read of a book by a user, from start to finish.
+// Reading a book again starts a new session marked as a re-read. Progress
+// is kept both as a page and as a percentage; when the book's page count is
+// known each is derived from the other.
+type ReadingSession struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	BookID     int64      `json:"book_id"`
+	Page       int        `json:"page" validate:"min_value=0"`
+	Percent    float64    `json:"percent" validate:"range=0:100"`
+	Reread     bool       `json:"reread"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	CreatedAt  time.Time  `json:"-"`
+	Version    int32      `json:"version"`
+}
+
+// readingSessionColumns matches the order of ReadingSession.scanFields.
+const readingSessionColumns = `id, user_id, book_id, page, percent, reread, started_at, finished_at, created_at, version`
+
+func (session *ReadingSession) scanFields() []any {
+	return []any{
+		&session.ID, &session.UserID, &session.BookID, &session.Page, &session.Percent,
+		&session.Reread, &session.StartedAt, &session.FinishedAt, &session.CreatedAt, &session.Version,
+	}
+}
+
+// SetPage records progress as a page number.
+func (session *ReadingSession) SetPage(page, pageCount int) {
+	session.Page = page
+	if pageCount > 0 {
+		session.Percent = math.Round(float64(page)*10000/float64(pageCount)) / 100
+	}
+}
+
+// SetPercent records progress as a percentage.
+func (session *ReadingSession) SetPercent(percent float64, pageCount int) {
+	session.Percent = percent
+	if pageCount > 0 {
+		session.Page = int(math.Round(percent * float64(pageCount) / 100))
+	}
+}
+
+// Finish marks the session finished at the given time, with the whole book
+// read.
+func (session *ReadingSession) Finish(at time.Time, pageCount int) {
+	session.FinishedAt = &at
+	session.SetPercent(100, pageCount)
+}
+
+// ValidateReadingSession checks the session against the book being read.
+func ValidateReadingSession(v *validator.Validator, session *ReadingSession, book *Book) {
+	v.Struct(session)
+	if book.PageCount > 0 {
+		v.Check(session.Page <= book.PageCount, "page", "max_value", "max", book.PageCount)
+	}
+	if session.FinishedAt != nil {
+		v.Check(!session.FinishedAt.Before(session.StartedAt), "finished_at", "later_than", "other", "started_at")
+	}
+}
+
+type ReadingSessionModel struct {
+	DB DBTX
+}
+
+// activeSessionError maps a clash on the one-unfinished-session index to
+// ErrActiveSession.
+func activeSessionError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "reading_sessions_active_idx" {
+		return ErrActiveSession
+	}
+	return err
+}
+
+// Insert starts a session. It is a re-read if the user has finished the book
+// before. It returns ErrActiveSession if the user is already reading the
+// book and ErrRecordNotFound if the book doesn't exist.
+func (m ReadingSessionModel) Insert(session *ReadingSession) error {
+	query := `
+		INSERT INTO reading_sessions (user_id, book_id, page, percent, started_at, finished_at, reread)
+		VALUES ($1, $2, $3, $4, $5, $6, EXISTS (
+			SELECT 1 FROM reading_sessions
+			WHERE user_id = $1 AND book_id = $2 AND finished_at IS NOT NULL
+		))
+		RETURNING id, reread, created_at, version`
+
+	args := []interface{}{session.UserID, session.BookID, session.Page, session.Percent, session.StartedAt, session.FinishedAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&session.ID, &session.Reread, &session.CreatedAt, &session.Version)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrRecordNotFound
+		}
+		return activeSessionError(err)
+	}
+
+	return nil
+}
+
+func (m ReadingSessionModel) Get(userID, sessionID int64) (*ReadingSession, error) {
+	if userID < 1 || sessionID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + readingSessionColumns + `
+		FROM reading_sessions
+		WHERE user_id = $1 AND id = $2`
+
+	var session ReadingSession
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, sessionID).Scan(session.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &session, nil
+}
+
+// Update saves a session's progress and dates. Reopening a finished session
+// returns ErrActiveSession if the user has since started the book again.
+func (m ReadingSessionModel) Update(session *ReadingSession) error {
+	query := `
+		UPDATE reading_sessions
+		SET page = $1, percent = $2, started_at = $3, finished_at = $4, version = version + 1
+		WHERE id = $5
+		RETURNING version`
+
+	args := []interface{}{session.Page, session.Percent, session.StartedAt, session.FinishedAt, session.ID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&session.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return activeSessionError(err)
+		}
+	}
+
+	return nil
+}
+
+func (m ReadingSessionModel) Delete(userID, sessionID int64) error {
+	if userID < 1 || sessionID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM reading_sessions
+		WHERE user_id = $1 AND id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetAllForUser lists a user's sessions, optionally for one book (bookID
+// not 0) and by status: "reading" for unfinished sessions, "finished" for
+// finished ones, or "" for both.
+func (m ReadingSessionModel) GetAllForUser(userID, bookID int64, status string, filters Filters) ([]*ReadingSession, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM reading_sessions
+		WHERE user_id = $1
+		AND (book_id = $2 OR $2 = 0)
+		AND ($3 = '' OR ($3 = 'reading') = (finished_at IS NULL))
+		ORDER BY %s %s, id ASC
+		LIMIT $4 OFFSET $5`, readingSessionColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, bookID, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	sessions := []*ReadingSession{}
+
+	for rows.Next() {
+		var session ReadingSession
+		err := rows.Scan(append([]any{&totalRecords}, session.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return sessions, metadata, nil
+}
+
+// Challenge is a user's progress through a calendar year (UTC) of reading.
+// Goal is 0 when the user hasn't set one. Each finished session counts,
+// re-reads included.
+type Challenge struct {
+	UserID          int64            `json:"user_id"`
+	Year            int              `json:"year"`
+	Goal            int              `json:"goal"`
+	BooksFinished   int              `json:"books_finished"`
+	BooksRemaining  int              `json:"books_remaining"`
+	PercentComplete float64          `json:"percent_complete"`
+	PagesRead       int              `json:"pages_read"`
+	Months          []ChallengeMonth `json:"months"`
+}
+
+type ChallengeMonth struct {
+	Month         int `json:"month"`
+	BooksFinished int `json:"books_finished"`
+	PagesRead     int `json:"pages_read"`
+}
+
+// SetGoal sets how many books the user means to finish in year.
+func (m ReadingSessionModel) SetGoal(userID int64, year, goal int) error {
+	query := `
+		INSERT INTO reading_goals (user_id, year, goal)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, year) DO UPDATE
+		SET goal = EXCLUDED.goal, version = reading_goals.version + 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, year, goal)
+	return err
+}
+
+// GetChallenge totals the sessions the user finished in year, month by
+// month, against their goal for it. A finished book counts all of its pages,
+// or the session's last page when the book's page count isn't known.
+func (m ReadingSessionModel) GetChallenge(userID int64, year int) (*Challenge, error) {
+	goalQuery := `
+		SELECT goal
+		FROM reading_goals
+		WHERE user_id = $1 AND year = $2`
+
+	monthsQuery := `
+		SELECT EXTRACT(MONTH FROM reading_sessions.finished_at AT TIME ZONE 'UTC')::integer, COUNT(*),
+			COALESCE(SUM(COALESCE(NULLIF(books.page_count, 0), reading_sessions.page)), 0)
+		FROM reading_sessions
+		INNER JOIN books ON books.id = reading_sessions.book_id
+		WHERE reading_sessions.user_id = $1
+		AND reading_sessions.finished_at >= make_timestamptz($2, 1, 1, 0, 0, 0, 'UTC')
+		AND reading_sessions.finished_at < make_timestamptz($2 + 1, 1, 1, 0, 0, 0, 'UTC')
+		GROUP BY 1`
+
+	challenge := &Challenge{
+		UserID: userID,
+		Year:   year,
+		Months: make([]ChallengeMonth, 12),
+	}
+	for i := range challenge.Months {
+		challenge.Months[i].Month = i + 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, goalQuery, userID, year).Scan(&challenge.Goal)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, monthsQuery, userID, year)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var month, books, pages int
+		err := rows.Scan(&month, &books, &pages)
+		if err != nil {
+			return nil, err
+		}
+		challenge.Months[month-1].BooksFinished = books
+		challenge.Months[month-1].PagesRead = pages
+		challenge.BooksFinished += books
+		challenge.PagesRead += pages
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if challenge.Goal > 0 {
+		challenge.BooksRemaining = max(challenge.Goal-challenge.BooksFinished, 0)
+		challenge.PercentComplete = math.Min(math.Round(float64(challenge.BooksFinished)*10000/float64(challenge.Goal))/100, 100)
+	}
+
+	return challenge, nil
+}
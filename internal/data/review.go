@@ -6,37 +6,134 @@ import (
 	"errors"
 	"fmt"
 	"time"
+
+	"github.com/lib/pq"
+	"github.com/tchenbz/AWTtest3/internal/markdown"
+	"github.com/tchenbz/AWTtest3/internal/policy"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+var (
+	ErrRecordNotFound  = errors.New("record not found")
+	ErrDuplicateReview = errors.New("user has already reviewed this book")
 )
 
-var ErrRecordNotFound = errors.New("record not found")
+const (
+	ReviewStatusPending  = "pending"
+	ReviewStatusApproved = "approved"
+	ReviewStatusRejected = "rejected"
+)
 
 type Review struct {
-	ID           int64     `json:"id"`
-	BookID    	 int64     `json:"book_id"`
-	Content      string    `json:"content"`
-	Author       string    `json:"author"`
-	Rating       int       `json:"rating"`         
-	HelpfulCount int       `json:"helpful_count"`  
-	CreatedAt    time.Time `json:"created_at"`
-	Version      int32     `json:"version"`
+	ID              int64          `json:"id"`
+	BookID          int64          `json:"book_id"`
+	UserID          int64          `json:"user_id,omitempty"`
+	Content         string         `json:"content"`
+	ContentHTML     string         `json:"content_html"`
+	ContentText     string         `json:"content_text"`
+	Author          string         `json:"author" validate:"required,max_len=100"`
+	Rating          int            `json:"rating"`
+	HelpfulCount    int            `json:"helpful_count" validate:"min_value=0"`
+	CommentCount    int            `json:"comment_count"`
+	Reactions       ReactionCounts `json:"reactions"`
+	Status          string         `json:"status"`
+	RejectionReason string         `json:"rejection_reason,omitempty"`
+	ModeratedBy     int64          `json:"moderated_by,omitempty"`
+	ModeratedAt     *time.Time     `json:"moderated_at,omitempty"`
+	Hidden          bool           `json:"hidden,omitempty"`
+	Draft           bool           `json:"draft"`
+	PublishAt       *time.Time     `json:"publish_at,omitempty"`
+	CreatedAt       time.Time      `json:"created_at"`
+	Version         int32          `json:"version"`
+}
+
+// reviewColumns matches the order of Review.scanFields.
+const reviewColumns = `id, book_id, COALESCE(user_id, 0), content, content_html, content_text, author, rating, helpful_count, comment_count, reaction_counts,
+		status, rejection_reason, COALESCE(moderated_by, 0), moderated_at, hidden, draft, publish_at, created_at, version`
+
+func (review *Review) scanFields() []any {
+	return []any{
+		&review.ID, &review.BookID, &review.UserID, &review.Content, &review.ContentHTML, &review.ContentText, &review.Author,
+		&review.Rating, &review.HelpfulCount, &review.CommentCount, &review.Reactions, &review.Status, &review.RejectionReason,
+		&review.ModeratedBy, &review.ModeratedAt, &review.Hidden, &review.Draft, &review.PublishAt, &review.CreatedAt, &review.Version,
+	}
+}
+
+// renderContent fills ContentHTML and ContentText from the Markdown in
+// Content. The model calls it on every write so the three never disagree.
+func (review *Review) renderContent() error {
+	var err error
+	review.ContentHTML, review.ContentText, err = markdown.Render(review.Content)
+	return err
+}
+
+// ValidateReview checks the review's fields and then applies the content
+// policy p to its content and rating.
+func ValidateReview(v *validator.Validator, review *Review, p *policy.Review) {
+	v.Struct(review)
+	p.Check(v, review.Content, review.Rating)
+}
+
+// refreshBookRating recomputes a book's average_rating from its visible
+// reviews. Drafts, hidden and unapproved reviews don't count.
+func refreshBookRating(ctx context.Context, tx *sql.Tx, bookID int64) error {
+	query := `
+		UPDATE books
+		SET average_rating = COALESCE((
+			SELECT AVG(rating) FROM reviews
+			WHERE book_id = $1 AND status = 'approved' AND NOT hidden AND NOT draft
+		), 0)
+		WHERE id = $1`
+
+	_, err := tx.ExecContext(ctx, query, bookID)
+	return err
 }
 
 type ReviewModel struct {
-	DB *sql.DB
+	DB DBTX
 }
 
-func (m ReviewModel) Insert(review *Review) error {
+// Insert returns ErrDuplicateReview if the user has already reviewed the
+// book.
+func (m ReviewModel) Insert(review *Review, event *AuditEvent) error {
 	query := `
-		INSERT INTO reviews (book_id, content, author, rating, helpful_count)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, created_at, version`
+		INSERT INTO reviews (book_id, user_id, content, content_html, content_text, author, rating, helpful_count, status, draft, publish_at)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, reaction_counts, created_at, version`
 
-	args := []interface{}{review.BookID, review.Content, review.Author, review.Rating, review.HelpfulCount}
+	err := review.renderContent()
+	if err != nil {
+		return err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	args := []interface{}{review.BookID, review.UserID, review.Content, review.ContentHTML, review.ContentText, review.Author, review.Rating, review.HelpfulCount, review.Status, review.Draft, review.PublishAt}
+
+	err = withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.Reactions, &review.CreatedAt, &review.Version)
+		if err != nil {
+			return err
+		}
+
+		err = refreshBookRating(ctx, tx, review.BookID)
+		if err != nil {
+			return err
+		}
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version)
+		err = recordReviewActivity(ctx, tx, review, 0)
+		if err != nil {
+			return err
+		}
+
+		event.ResourceID = review.ID
+		event.After, err = snapshot(review)
+		return err
+	})
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "reviews_book_id_user_id_idx" {
+		return ErrDuplicateReview
+	}
+	return err
 }
 
 func (m ReviewModel) Get(bookID, reviewID int64) (*Review, error) {
@@ -45,19 +142,16 @@ func (m ReviewModel) Get(bookID, reviewID int64) (*Review, error) {
 	}
 
 	query := `
-		SELECT id, book_id, content, author, rating, helpful_count, created_at, version
+		SELECT ` + reviewColumns + `
 		FROM reviews
-		WHERE product_id = $1 AND id = $2`
+		WHERE book_id = $1 AND id = $2`
 
 	var review Review
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, bookID, reviewID).Scan(
-		&review.ID, &review.BookID, &review.Content, &review.Author, 
-		&review.Rating, &review.HelpfulCount, &review.CreatedAt, &review.Version,
-	)
+	err := m.DB.QueryRowContext(ctx, query, bookID, reviewID).Scan(review.scanFields()...)
 
 	if err != nil {
 		switch {
@@ -71,78 +165,296 @@ func (m ReviewModel) Get(bookID, reviewID int64) (*Review, error) {
 	return &review, nil
 }
 
-func (m ReviewModel) Update(review *Review) error {
+// GetForUser returns userID's review of a book, whatever its status.
+func (m ReviewModel) GetForUser(bookID, userID int64) (*Review, error) {
+	if bookID < 1 || userID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
 	query := `
-		UPDATE reviews
-		SET content = $1, author = $2, rating = $3, helpful_count = $4, version = version + 1
-		WHERE product_id = $5 AND id = $6
-		RETURNING version`
+		SELECT ` + reviewColumns + `
+		FROM reviews
+		WHERE book_id = $1 AND user_id = $2`
 
-	args := []interface{}{review.Content, review.Author, review.Rating, review.HelpfulCount, review.BookID, review.ID}
+	var review Review
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+	err := m.DB.QueryRowContext(ctx, query, bookID, userID).Scan(review.scanFields()...)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrRecordNotFound
+			return nil, ErrRecordNotFound
 		default:
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return &review, nil
 }
 
-func (m ReviewModel) Delete(bookID, reviewID int64) error {
-	if bookID < 1 || reviewID < 1 {
-		return ErrRecordNotFound
+// Update saves the review's editable fields, its status, which the caller
+// sets back to pending when an edit needs moderating again, and its draft
+// state. The helpful count only changes through votes.
+func (m ReviewModel) Update(review *Review, event *AuditEvent) error {
+	query := `
+		UPDATE reviews
+		SET content = $1, content_html = $2, content_text = $3, author = $4, rating = $5,
+			status = $6, rejection_reason = $7, draft = $8, publish_at = $9, version = version + 1
+		WHERE book_id = $10 AND id = $11
+		RETURNING version, helpful_count`
+
+	err := review.renderContent()
+	if err != nil {
+		return err
 	}
 
+	args := []interface{}{review.Content, review.ContentHTML, review.ContentText, review.Author, review.Rating, review.Status, review.RejectionReason, review.Draft, review.PublishAt, review.BookID, review.ID}
+
+	return withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getReviewForUpdate(ctx, tx, review.BookID, review.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&review.Version, &review.HelpfulCount)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		err = refreshBookRating(ctx, tx, review.BookID)
+		if err != nil {
+			return err
+		}
+
+		err = recordReviewActivity(ctx, tx, review, before.Rating)
+		if err != nil {
+			return err
+		}
+
+		event.ResourceID = review.ID
+		event.Before, err = snapshot(before)
+		if err != nil {
+			return err
+		}
+		event.After, err = snapshot(review)
+		return err
+	})
+}
+
+func getReviewForUpdate(ctx context.Context, tx *sql.Tx, bookID, reviewID int64) (*Review, error) {
 	query := `
-		DELETE FROM reviews
-		WHERE book_id = $1 AND id = $2`
+		SELECT ` + reviewColumns + `
+		FROM reviews
+		WHERE book_id = $1 AND id = $2
+		FOR UPDATE`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	var review Review
+
+	err := tx.QueryRowContext(ctx, query, bookID, reviewID).Scan(review.scanFields()...)
 
-	result, err := m.DB.ExecContext(ctx, query, bookID, reviewID)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
+	return &review, nil
+}
+
+// Moderate sets a review's status on behalf of moderatorID and returns the
+// updated review. reason is kept only for rejections. Either decision
+// resolves the review's open flags, and approving also un-hides it.
+func (m ReviewModel) Moderate(reviewID int64, status, reason string, moderatorID int64, event *AuditEvent) (*Review, error) {
+	if reviewID < 1 {
+		return nil, ErrRecordNotFound
+	}
+	if status != ReviewStatusRejected {
+		reason = ""
+	}
+
+	selectQuery := `
+		SELECT ` + reviewColumns + `
+		FROM reviews
+		WHERE id = $1
+		FOR UPDATE`
+
+	updateQuery := `
+		UPDATE reviews
+		SET status = $1, rejection_reason = $2, moderated_by = NULLIF($3, 0), moderated_at = NOW(),
+			hidden = hidden AND $1 <> 'approved', version = version + 1
+		WHERE id = $4
+		RETURNING ` + reviewColumns
+
+	resolveQuery := `
+		UPDATE review_flags
+		SET resolved_at = NOW()
+		WHERE review_id = $1 AND resolved_at IS NULL`
+
+	var review Review
+
+	err := withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		var before Review
+		err := tx.QueryRowContext(ctx, selectQuery, reviewID).Scan(before.scanFields()...)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		err = tx.QueryRowContext(ctx, updateQuery, status, reason, moderatorID, reviewID).Scan(review.scanFields()...)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, resolveQuery, reviewID)
+		if err != nil {
+			return err
+		}
+
+		err = refreshBookRating(ctx, tx, review.BookID)
+		if err != nil {
+			return err
+		}
+
+		err = recordReviewActivity(ctx, tx, &review, before.Rating)
+		if err != nil {
+			return err
+		}
+
+		event.ResourceID = review.ID
+		event.Before, err = snapshot(before)
+		if err != nil {
+			return err
+		}
+		event.After, err = snapshot(review)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	if rowsAffected == 0 {
+	return &review, nil
+}
+
+func (m ReviewModel) Delete(bookID, reviewID int64, event *AuditEvent) error {
+	if bookID < 1 || reviewID < 1 {
 		return ErrRecordNotFound
 	}
 
-	return nil
+	query := `
+		DELETE FROM reviews
+		WHERE book_id = $1 AND id = $2
+		RETURNING ` + reviewColumns
+
+	return withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		var review Review
+
+		err := tx.QueryRowContext(ctx, query, bookID, reviewID).Scan(review.scanFields()...)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		err = refreshBookRating(ctx, tx, bookID)
+		if err != nil {
+			return err
+		}
+
+		event.ResourceID = review.ID
+		event.Before, err = snapshot(review)
+		return err
+	})
 }
 
-func (m ReviewModel) GetAll(content, author string, rating int, filters Filters) ([]*Review, Metadata, error) {
+// GetAll lists published, approved reviews that have not been hidden by
+// flags, plus any other reviews, drafts included, written by viewerID so
+// authors can follow their own submissions. viewerID is 0 for anonymous
+// callers.
+func (m ReviewModel) GetAll(content, author string, rating int, viewerID int64, filters Filters) ([]*Review, Metadata, error) {
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, book_id, content, author, rating, helpful_count, created_at, version
+		SELECT COUNT(*) OVER(), %s
 		FROM reviews
-		WHERE (content ILIKE $1 OR $1 = '')
+		WHERE (content_text ILIKE $1 OR $1 = '')
 		AND (author ILIKE $2 OR $2 = '')
 		AND (rating = $3 OR $3 = 0)
+		AND ((status = 'approved' AND NOT hidden AND NOT draft) OR ($4 <> 0 AND user_id = $4))
 		ORDER BY %s %s, id ASC
-		LIMIT $4 OFFSET $5`, filters.sortColumn(), filters.sortDirection())
+		LIMIT $5 OFFSET $6`, reviewColumns, filters.sortColumn(), filters.sortDirection())
 
 	args := []interface{}{
 		"%" + content + "%",
 		"%" + author + "%",
 		rating,
+		viewerID,
 		filters.limit(),
 		filters.offset(),
 	}
 
+	return m.list(query, args, filters)
+}
+
+// GetAllForBook is GetAll restricted to one book.
+func (m ReviewModel) GetAllForBook(bookID int64, content, author string, rating int, viewerID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM reviews
+		WHERE book_id = $1
+		AND (content_text ILIKE $2 OR $2 = '')
+		AND (author ILIKE $3 OR $3 = '')
+		AND (rating = $4 OR $4 = 0)
+		AND ((status = 'approved' AND NOT hidden AND NOT draft) OR ($5 <> 0 AND user_id = $5))
+		ORDER BY %s %s, id ASC
+		LIMIT $6 OFFSET $7`, reviewColumns, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{
+		bookID,
+		"%" + content + "%",
+		"%" + author + "%",
+		rating,
+		viewerID,
+		filters.limit(),
+		filters.offset(),
+	}
+
+	return m.list(query, args, filters)
+}
+
+// GetAllByStatus is the moderation queue: every review with the given status,
+// optionally for one book (bookID 0 means all books).
+func (m ReviewModel) GetAllByStatus(status string, bookID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM reviews
+		WHERE status = $1 AND NOT draft
+		AND (book_id = $2 OR $2 = 0)
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, reviewColumns, filters.sortColumn(), filters.sortDirection())
+
+	args := []interface{}{status, bookID, filters.limit(), filters.offset()}
+
+	return m.list(query, args, filters)
+}
+
+// list runs a paged query whose first column is COUNT(*) OVER() followed by
+// reviewColumns.
+func (m ReviewModel) list(query string, args []any, filters Filters) ([]*Review, Metadata, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -157,17 +469,7 @@ func (m ReviewModel) GetAll(content, author string, rating int, filters Filters)
 
 	for rows.Next() {
 		var review Review
-		err := rows.Scan(
-			&totalRecords,
-			&review.ID,
-			&review.BookID,
-			&review.Content,
-			&review.Author,
-			&review.Rating,
-			&review.HelpfulCount,
-			&review.CreatedAt,
-			&review.Version,
-		)
+		err := rows.Scan(append([]any{&totalRecords}, review.scanFields()...)...)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
@@ -182,61 +484,102 @@ func (m ReviewModel) GetAll(content, author string, rating int, filters Filters)
 	return reviews, metadata, nil
 }
 
-func (m ReviewModel) GetAllForBook(bookID int64, content, author string, rating int, filters Filters) ([]*Review, Metadata, error) {
+// Export streams every visible review matching the list filters to fn, in
+// the order given by filters.Sort. Paging fields on filters are ignored.
+func (m ReviewModel) Export(content, author string, rating int, filters Filters, fn func(*Review) error) error {
 	query := fmt.Sprintf(`
-		SELECT COUNT(*) OVER(), id, book_id, content, author, rating, helpful_count, created_at, version
+		SELECT %s
 		FROM reviews
-		WHERE book_id = $1
-		AND (content ILIKE $2 OR $2 = '')
-		AND (author ILIKE $3 OR $3 = '')
-		AND (rating = $4 OR $4 = 0)
-		ORDER BY %s %s, id ASC
-		LIMIT $5 OFFSET $6`, filters.sortColumn(), filters.sortDirection())
+		WHERE (content_text ILIKE $1 OR $1 = '')
+		AND (author ILIKE $2 OR $2 = '')
+		AND (rating = $3 OR $3 = 0)
+		AND status = 'approved' AND NOT hidden AND NOT draft
+		ORDER BY %s %s, id ASC`, reviewColumns, filters.sortColumn(), filters.sortDirection())
 
 	args := []interface{}{
-		bookID,
 		"%" + content + "%",
 		"%" + author + "%",
 		rating,
-		filters.limit(),
-		filters.offset(),
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	return streamRows(m.DB, query, args, func(rows *sql.Rows) error {
+		var review Review
+		err := rows.Scan(review.scanFields()...)
+		if err != nil {
+			return err
+		}
+		return fn(&review)
+	})
+}
+
+// PublishDue publishes every draft whose publish_at has passed, recording an
+// audit event for each, and returns how many were published.
+func (m ReviewModel) PublishDue() (int, error) {
+	query := `
+		UPDATE reviews
+		SET draft = false, version = version + 1
+		WHERE draft AND publish_at <= NOW()
+		RETURNING ` + reviewColumns
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	scope, err := begin(ctx, m.DB, nil)
 	if err != nil {
-		return nil, Metadata{}, err
+		return 0, err
 	}
-	defer rows.Close()
+	defer scope.rollback()
 
-	totalRecords := 0
-	reviews := []*Review{}
+	rows, err := scope.tx.QueryContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
 
+	var published []*Review
 	for rows.Next() {
 		var review Review
-		err := rows.Scan(
-			&totalRecords,
-			&review.ID,
-			&review.BookID,
-			&review.Content,
-			&review.Author,
-			&review.Rating,
-			&review.HelpfulCount,
-			&review.CreatedAt,
-			&review.Version,
-		)
+		err := rows.Scan(review.scanFields()...)
 		if err != nil {
-			return nil, Metadata{}, err
+			rows.Close()
+			return 0, err
 		}
-		reviews = append(reviews, &review)
+		published = append(published, &review)
 	}
-
+	rows.Close()
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return 0, err
 	}
 
-	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
-	return reviews, metadata, nil
-}
\ No newline at end of file
+	books := make(map[int64]bool)
+	for _, review := range published {
+		event := &AuditEvent{Resource: "review", ResourceID: review.ID, Action: AuditActionPublish}
+		event.After, err = snapshot(review)
+		if err != nil {
+			return 0, err
+		}
+		err = insertAuditEvent(ctx, scope.tx, event)
+		if err != nil {
+			return 0, err
+		}
+
+		err = recordReviewActivity(ctx, scope.tx, review, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		if !books[review.BookID] {
+			books[review.BookID] = true
+			err = refreshBookRating(ctx, scope.tx, review.BookID)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	err = scope.commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return len(published), nil
+}
@@ -0,0 +1,535 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+// ErrDuplicateShelf is returned when a user already has a shelf with the
+// same slug.
+var ErrDuplicateShelf = errors.New("duplicate shelf")
+
+const (
+	ShelfWantToRead       = "want-to-read"
+	ShelfCurrentlyReading = "currently-reading"
+	ShelfRead             = "read"
+)
+
+// builtInShelves are created for every user. They are exclusive: a book is
+// on at most one of them at a time, so adding it to one moves it off the
+// others. Each has a counter column on books.
+var builtInShelves = []struct {
+	slug, name, countColumn string
+}{
+	{ShelfWantToRead, "Want to Read", "want_to_read_count"},
+	{ShelfCurrentlyReading, "Currently Reading", "currently_reading_count"},
+	{ShelfRead, "Read", "read_count"},
+}
+
+// ShelfCounts is how many users have a book on each built-in shelf, kept on
+// the book as a popularity signal.
+type ShelfCounts struct {
+	WantToRead       int `json:"want_to_read"`
+	CurrentlyReading int `json:"currently_reading"`
+	Read             int `json:"read"`
+}
+
+// Shelf is one of a user's built-in shelves or a tag shelf they created.
+type Shelf struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name" validate:"required,max_len=50"`
+	Slug      string    `json:"slug"`
+	Exclusive bool      `json:"exclusive"`
+	Public    bool      `json:"public"`
+	BookCount int       `json:"book_count"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int32     `json:"version"`
+}
+
+// shelfColumns matches the order of Shelf.scanFields.
+const shelfColumns = `id, user_id, name, slug, exclusive, public,
+		(SELECT COUNT(*) FROM shelf_books WHERE shelf_id = shelves.id) AS book_count, created_at, version`
+
+func (shelf *Shelf) scanFields() []any {
+	return []any{
+		&shelf.ID, &shelf.UserID, &shelf.Name, &shelf.Slug, &shelf.Exclusive,
+		&shelf.Public, &shelf.BookCount, &shelf.CreatedAt, &shelf.Version,
+	}
+}
+
+// ShelfEntry is a book on a shelf.
+type ShelfEntry struct {
+	Book    *Book     `json:"book"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// Slugify turns a shelf name into its URL form: lower case, with each run
+// of anything other than letters and digits replaced by a single hyphen.
+func Slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+func ValidateShelf(v *validator.Validator, shelf *Shelf) {
+	v.Struct(shelf)
+	if shelf.Name != "" {
+		v.Check(shelf.Slug != "", "name", "shelf_name")
+	}
+}
+
+type ShelfModel struct {
+	DB DBTX
+}
+
+// EnsureBuiltIns creates any of the user's built-in shelves that don't exist
+// yet.
+func (m ShelfModel) EnsureBuiltIns(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return ensureBuiltInShelves(ctx, m.DB, userID)
+}
+
+func ensureBuiltInShelves(ctx context.Context, db DBTX, userID int64) error {
+	query := `
+		INSERT INTO shelves (user_id, name, slug, exclusive)
+		SELECT $1, name, slug, true
+		FROM unnest($2::text[], $3::text[]) AS s(name, slug)
+		ON CONFLICT (user_id, slug) DO NOTHING`
+
+	var names, slugs []string
+	for _, shelf := range builtInShelves {
+		names = append(names, shelf.name)
+		slugs = append(slugs, shelf.slug)
+	}
+
+	_, err := db.ExecContext(ctx, query, userID, pq.Array(names), pq.Array(slugs))
+	return err
+}
+
+// Insert creates a tag shelf. It returns ErrDuplicateShelf if the name's
+// slug is already taken, including by a built-in shelf.
+func (m ShelfModel) Insert(shelf *Shelf, event *AuditEvent) error {
+	query := `
+		INSERT INTO shelves (user_id, name, slug, public)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{shelf.UserID, shelf.Name, shelf.Slug, shelf.Public}
+
+	err := withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		err := ensureBuiltInShelves(ctx, tx, shelf.UserID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&shelf.ID, &shelf.CreatedAt, &shelf.Version)
+		if err != nil {
+			return err
+		}
+
+		event.ResourceID = shelf.ID
+		event.After, err = snapshot(shelf)
+		return err
+	})
+
+	return duplicateShelfError(err)
+}
+
+func duplicateShelfError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "shelves_user_id_slug_key" {
+		return ErrDuplicateShelf
+	}
+	return err
+}
+
+func (m ShelfModel) Get(userID int64, slug string) (*Shelf, error) {
+	if userID < 1 || slug == "" {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT ` + shelfColumns + `
+		FROM shelves
+		WHERE user_id = $1 AND slug = $2`
+
+	var shelf Shelf
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, slug).Scan(shelf.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &shelf, nil
+}
+
+// getShelfForUpdate locks the row so the audit "before" snapshot matches
+// what the update replaces.
+func getShelfForUpdate(ctx context.Context, tx *sql.Tx, id int64) (*Shelf, error) {
+	query := `
+		SELECT ` + shelfColumns + `
+		FROM shelves
+		WHERE id = $1
+		FOR UPDATE`
+
+	var shelf Shelf
+
+	err := tx.QueryRowContext(ctx, query, id).Scan(shelf.scanFields()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &shelf, nil
+}
+
+// Update saves a shelf's name, slug and visibility.
+func (m ShelfModel) Update(shelf *Shelf, event *AuditEvent) error {
+	query := `
+		UPDATE shelves
+		SET name = $1, slug = $2, public = $3, version = version + 1
+		WHERE id = $4
+		RETURNING version`
+
+	args := []interface{}{shelf.Name, shelf.Slug, shelf.Public, shelf.ID}
+
+	err := withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getShelfForUpdate(ctx, tx, shelf.ID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&shelf.Version)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrRecordNotFound
+			default:
+				return err
+			}
+		}
+
+		event.ResourceID = shelf.ID
+		event.Before, err = snapshot(before)
+		if err != nil {
+			return err
+		}
+		event.After, err = snapshot(shelf)
+		return err
+	})
+
+	return duplicateShelfError(err)
+}
+
+// Delete removes a tag shelf and its entries. Built-in shelves are never
+// deleted.
+func (m ShelfModel) Delete(id int64, event *AuditEvent) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+		DELETE FROM shelves
+		WHERE id = $1 AND NOT exclusive`
+
+	return withAudit(m.DB, event, func(ctx context.Context, tx *sql.Tx) error {
+		before, err := getShelfForUpdate(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		event.ResourceID = id
+		event.Before, err = snapshot(before)
+		return err
+	})
+}
+
+// GetAllForUser lists a user's shelves. Private shelves are included only
+// when includePrivate is set.
+func (m ShelfModel) GetAllForUser(userID int64, includePrivate bool, filters Filters) ([]*Shelf, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), %s
+		FROM shelves
+		WHERE user_id = $1
+		AND (public OR $2)
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, shelfColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, includePrivate, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	shelves := []*Shelf{}
+
+	for rows.Next() {
+		var shelf Shelf
+		err := rows.Scan(append([]any{&totalRecords}, shelf.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		shelves = append(shelves, &shelf)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return shelves, metadata, nil
+}
+
+// GetBooks lists the books on a shelf. Sorting by "added_at" orders by when
+// each book was shelved; other sort columns are book columns.
+func (m ShelfModel) GetBooks(shelfID int64, filters Filters) ([]*ShelfEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT COUNT(*) OVER(), shelf_books.added_at, %s
+		FROM shelf_books
+		JOIN books ON books.id = shelf_books.book_id
+		WHERE shelf_books.shelf_id = $1
+		ORDER BY %s %s, books.id ASC
+		LIMIT $2 OFFSET $3`, bookColumns, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, shelfID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*ShelfEntry{}
+
+	for rows.Next() {
+		entry := ShelfEntry{Book: &Book{}}
+		err := rows.Scan(append([]any{&totalRecords, &entry.AddedAt}, entry.Book.scanFields()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetaData(totalRecords, filters.Page, filters.PageSize)
+	return entries, metadata, nil
+}
+
+// adjustShelfCount adds delta to a book's counter for the built-in shelf
+// with the given slug. Tag shelves have no counter.
+func adjustShelfCount(ctx context.Context, tx *sql.Tx, bookID int64, slug string, delta int) error {
+	for _, shelf := range builtInShelves {
+		if shelf.slug != slug {
+			continue
+		}
+
+		query := fmt.Sprintf(`
+			UPDATE books
+			SET %[1]s = GREATEST(%[1]s + $2, 0)
+			WHERE id = $1`, shelf.countColumn)
+
+		_, err := tx.ExecContext(ctx, query, bookID, delta)
+		return err
+	}
+	return nil
+}
+
+// AddBook puts a book on a shelf and returns the entry. Adding a book to a
+// built-in shelf moves it off the user's other built-in shelves. Adding a
+// book that is already there leaves it as it was. Shelving a book publicly
+// shows up in the user's followers' feeds. It returns ErrRecordNotFound if
+// the book doesn't exist.
+func (m ShelfModel) AddBook(shelf *Shelf, bookID int64) (*ShelfEntry, error) {
+	// Locking the user's built-in shelves serializes concurrent moves, so
+	// the exclusive index can't be hit by a race.
+	lockQuery := `
+		SELECT id FROM shelves
+		WHERE user_id = $1 AND exclusive
+		FOR UPDATE`
+
+	moveQuery := `
+		DELETE FROM shelf_books
+		USING shelves
+		WHERE shelves.id = shelf_books.shelf_id
+		AND shelf_books.user_id = $1 AND shelf_books.book_id = $2
+		AND shelf_books.exclusive AND shelf_books.shelf_id <> $3
+		RETURNING shelves.slug`
+
+	insertQuery := `
+		INSERT INTO shelf_books (shelf_id, book_id, user_id, exclusive)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`
+
+	entryQuery := fmt.Sprintf(`
+		SELECT shelf_books.added_at, %s
+		FROM shelf_books
+		JOIN books ON books.id = shelf_books.book_id
+		WHERE shelf_books.shelf_id = $1 AND shelf_books.book_id = $2`, bookColumns)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scope, err := begin(ctx, m.DB, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer scope.rollback()
+
+	if shelf.Exclusive {
+		_, err = scope.tx.ExecContext(ctx, lockQuery, shelf.UserID)
+		if err != nil {
+			return nil, err
+		}
+
+		var previous string
+		err = scope.tx.QueryRowContext(ctx, moveQuery, shelf.UserID, bookID, shelf.ID).Scan(&previous)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return nil, err
+		default:
+			err = adjustShelfCount(ctx, scope.tx, bookID, previous, -1)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result, err := scope.tx.ExecContext(ctx, insertQuery, shelf.ID, bookID, shelf.UserID, shelf.Exclusive)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return nil, ErrRecordNotFound
+		}
+		return nil, err
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if added > 0 && shelf.Exclusive {
+		err = adjustShelfCount(ctx, scope.tx, bookID, shelf.Slug, 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if added > 0 && shelf.Public {
+		_, err = recordActivity(ctx, scope.tx, &Activity{
+			ActorID: shelf.UserID,
+			Verb:    ActivityShelve,
+			BookID:  bookID,
+			ShelfID: shelf.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entry := ShelfEntry{Book: &Book{}}
+	err = scope.tx.QueryRowContext(ctx, entryQuery, shelf.ID, bookID).Scan(append([]any{&entry.AddedAt}, entry.Book.scanFields()...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = scope.commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+// RemoveBook takes a book off a shelf. It returns ErrRecordNotFound if the
+// book isn't on it.
+func (m ShelfModel) RemoveBook(shelf *Shelf, bookID int64) error {
+	query := `
+		DELETE FROM shelf_books
+		WHERE shelf_id = $1 AND book_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scope, err := begin(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer scope.rollback()
+
+	result, err := scope.tx.ExecContext(ctx, query, shelf.ID, bookID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	if shelf.Exclusive {
+		err = adjustShelfCount(ctx, scope.tx, bookID, shelf.Slug, -1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scope.commit()
+}
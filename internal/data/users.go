@@ -0,0 +1,310 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tchenbz/AWTtest3/internal/validator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrDuplicateEmail = errors.New("duplicate email")
+
+// User is the caller identified by the authenticate middleware, and an
+// account in the users table. Bearer tokens, whether issued by
+// POST /v1/tokens/authentication or signed elsewhere with the same secret,
+// name the user by users.id in their sub claim.
+type User struct {
+	ID          int64       `json:"id"`
+	Email       string      `json:"email"`
+	Password    password    `json:"-"`
+	Activated   bool        `json:"activated"`
+	CreatedAt   time.Time   `json:"created_at"`
+	Version     int         `json:"-"`
+	Permissions Permissions `json:"-"`
+	// APIKeyID is the key the request was made with, or 0 for a bearer
+	// token.
+	APIKeyID int64 `json:"-"`
+}
+
+var AnonymousUser = &User{}
+
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
+}
+
+type password struct {
+	hash []byte
+}
+
+// Set stores the bcrypt hash of plaintext.
+func (p *password) Set(plaintext string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), 12)
+	if err != nil {
+		return err
+	}
+
+	p.hash = hash
+	return nil
+}
+
+// dummyPasswordHash is compared against when there is no user, so a failed
+// sign-in takes as long whether or not the account exists.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a real password"), 12)
+	return hash
+})
+
+// PasswordMatches reports whether plaintext is user's password. A nil user
+// never matches.
+func PasswordMatches(user *User, plaintext string) (bool, error) {
+	hash := dummyPasswordHash()
+	if user != nil {
+		hash = user.Password.hash
+	}
+
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintext))
+	switch {
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return user != nil, nil
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "required")
+	v.Check(email == "" || validator.IsEmail(email), "email", "email")
+}
+
+// ValidatePasswordPlaintext checks a new password. bcrypt ignores anything
+// past 72 bytes, so longer passwords are refused rather than truncated.
+func ValidatePasswordPlaintext(v *validator.Validator, password string) {
+	if password == "" {
+		v.AddError("password", "required")
+		return
+	}
+	v.Check(validator.MinRunes(password, 8), "password", "min_len", "min", 8)
+	v.Check(len(password) <= 72, "password", "max_bytes", "max", 72)
+}
+
+type UserModel struct {
+	DB DBTX
+}
+
+// Insert creates an inactive account, returning ErrDuplicateEmail if the
+// email address is taken.
+func (m UserModel) Insert(user *User) error {
+	query := `
+		INSERT INTO users (email, password_hash, activated)
+		VALUES ($1, $2, $3)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, user.Email, user.Password.hash, user.Activated).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "users_email_key" {
+		return ErrDuplicateEmail
+	}
+	return err
+}
+
+func (m UserModel) GetByEmail(email string) (*User, error) {
+	query := `
+		SELECT id, email, password_hash, activated, created_at, version
+		FROM users
+		WHERE email = $1`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.CreatedAt,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// lockForToken finds and locks the user holding a live token of the given
+// scope, along with the token.
+func lockForToken(ctx context.Context, tx *sql.Tx, scope, tokenPlaintext string) (*User, error) {
+	query := `
+		SELECT users.id, users.email, users.activated, users.created_at, users.version
+		FROM users
+		INNER JOIN tokens ON tokens.user_id = users.id
+		WHERE tokens.hash = $1
+		AND tokens.scope = $2
+		AND tokens.expiry > NOW()
+		FOR UPDATE`
+
+	var user User
+
+	err := tx.QueryRowContext(ctx, query, hashToken(tokenPlaintext), scope).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Activated,
+		&user.CreatedAt,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// ResetPassword sets the password of the user holding the password reset
+// token, uses up all of their reset tokens, and revokes the bearer tokens
+// issued so far and all of their API keys. An unknown or expired token is
+// ErrRecordNotFound.
+func (m UserModel) ResetPassword(tokenPlaintext, newPassword string) (*User, error) {
+	updateQuery := `
+		UPDATE users
+		SET password_hash = $1, sessions_revoked_at = NOW(), version = version + 1
+		WHERE id = $2
+		RETURNING version`
+
+	deleteQuery := `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND scope = $2`
+
+	revokeKeysQuery := `
+		UPDATE api_keys
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND (revoked_at IS NULL OR revoked_at > NOW())`
+
+	// Hash before taking any locks; bcrypt is deliberately slow.
+	var newHash password
+	err := newHash.Set(newPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scope, err := begin(ctx, m.DB, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer scope.rollback()
+
+	user, err := lockForToken(ctx, scope.tx, ScopePasswordReset, tokenPlaintext)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = newHash
+
+	err = scope.tx.QueryRowContext(ctx, updateQuery, user.Password.hash, user.ID).Scan(&user.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = scope.tx.ExecContext(ctx, deleteQuery, user.ID, ScopePasswordReset)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = scope.tx.ExecContext(ctx, revokeKeysQuery, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = scope.commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Activate activates the user holding the activation token and uses up all
+// of their activation tokens. An unknown or expired token is
+// ErrRecordNotFound.
+func (m UserModel) Activate(tokenPlaintext string) (*User, error) {
+	updateQuery := `
+		UPDATE users
+		SET activated = true, version = version + 1
+		WHERE id = $1
+		RETURNING version`
+
+	deleteQuery := `
+		DELETE FROM tokens
+		WHERE user_id = $1 AND scope = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	scope, err := begin(ctx, m.DB, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer scope.rollback()
+
+	user, err := lockForToken(ctx, scope.tx, ScopeActivation, tokenPlaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	err = scope.tx.QueryRowContext(ctx, updateQuery, user.ID).Scan(&user.Version)
+	if err != nil {
+		return nil, err
+	}
+	user.Activated = true
+
+	_, err = scope.tx.ExecContext(ctx, deleteQuery, user.ID, ScopeActivation)
+	if err != nil {
+		return nil, err
+	}
+
+	err = scope.commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// SessionStatus reports whether a bearer token the user was issued at
+// issuedAt has since been revoked, and whether the user is activated. A
+// token's sub is a users.id; a sub without an account row has nothing to
+// revoke but is never activated.
+func (m UserModel) SessionStatus(userID int64, issuedAt time.Time) (revoked, activated bool, err error) {
+	query := `
+		SELECT COALESCE(bool_or(sessions_revoked_at > $2), false), COALESCE(bool_and(activated), false)
+		FROM users
+		WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err = m.DB.QueryRowContext(ctx, query, userID, issuedAt).Scan(&revoked, &activated)
+	return revoked, activated, err
+}
@@ -0,0 +1,135 @@
+// Package i18n renders message codes in the language a client asks for.
+// Catalogs are JSON files in locales/, embedded in the binary, mapping a code
+// to a template in which {name} is replaced by the matching parameter.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+type Bundle struct {
+	catalogs map[string]map[string]string
+}
+
+func New() (*Bundle, error) {
+	files, err := localeFS.ReadDir("locales")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{catalogs: make(map[string]map[string]string)}
+	for _, file := range files {
+		js, err := localeFS.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var catalog map[string]string
+		err = json.Unmarshal(js, &catalog)
+		if err != nil {
+			return nil, fmt.Errorf("i18n: %s: %w", file.Name(), err)
+		}
+		b.catalogs[strings.TrimSuffix(file.Name(), ".json")] = catalog
+	}
+
+	if _, ok := b.catalogs[DefaultLanguage]; !ok {
+		return nil, fmt.Errorf("i18n: no catalog for default language %q", DefaultLanguage)
+	}
+
+	return b, nil
+}
+
+// Languages returns the language tags that have a catalog.
+func (b *Bundle) Languages() []string {
+	languages := make([]string, 0, len(b.catalogs))
+	for language := range b.catalogs {
+		languages = append(languages, language)
+	}
+	slices.Sort(languages)
+	return languages
+}
+
+// Negotiate picks the best supported language for an Accept-Language header,
+// matching "fr-CA" against a "fr" catalog and falling back to
+// DefaultLanguage.
+func (b *Bundle) Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		tag     string
+		quality float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{tag: tag, quality: quality})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+
+	for _, c := range candidates {
+		if c.tag == "*" {
+			return DefaultLanguage
+		}
+		if _, ok := b.catalogs[c.tag]; ok {
+			return c.tag
+		}
+		base, _, _ := strings.Cut(c.tag, "-")
+		if _, ok := b.catalogs[base]; ok {
+			return base
+		}
+	}
+
+	return DefaultLanguage
+}
+
+// Translate renders code in language, falling back to the default language
+// and finally to the code itself so a message is never lost.
+func (b *Bundle) Translate(language, code string, params map[string]any) string {
+	template, ok := b.catalogs[language][code]
+	if !ok {
+		template, ok = b.catalogs[DefaultLanguage][code]
+	}
+	if !ok {
+		return code
+	}
+
+	if len(params) == 0 {
+		return template
+	}
+
+	replacements := make([]string, 0, len(params)*2)
+	for name, value := range params {
+		replacements = append(replacements, "{"+name+"}", fmt.Sprint(value))
+	}
+	return strings.NewReplacer(replacements...).Replace(template)
+}
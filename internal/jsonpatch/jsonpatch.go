@@ -0,0 +1,397 @@
+// Package jsonpatch applies JSON Merge Patch (RFC 7396) and JSON Patch
+// (RFC 6902) documents to JSON values.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrTestFailed is returned when a JSON Patch "test" operation does not
+	// match, in which case nothing is applied.
+	ErrTestFailed   = errors.New("jsonpatch: test operation failed")
+	ErrInvalidPatch = errors.New("jsonpatch: invalid patch")
+)
+
+// OperationError reports which JSON Patch operation could not be applied.
+// It wraps ErrInvalidPatch or ErrTestFailed.
+type OperationError struct {
+	Index int
+	Path  string
+	Err   error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// MergePatch applies patch to doc as described in RFC 7396: objects are merged
+// recursively, a null member removes the key, and anything else replaces the
+// target value.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p any
+	if err := decode(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := decode(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = mergeValue(targetObject[key], value)
+	}
+	return targetObject
+}
+
+type operation struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// Apply applies the RFC 6902 operations in patch to doc. The operations are
+// all-or-nothing: if any of them fails, including a "test", an error is
+// returned and doc is left as it was.
+func Apply(doc, patch []byte) ([]byte, error) {
+	var target any
+	if err := decode(doc, &target); err != nil {
+		return nil, err
+	}
+
+	var ops []operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: the body must be an array of operations", ErrInvalidPatch)
+	}
+
+	for i, op := range ops {
+		if op.Path == nil {
+			return nil, fmt.Errorf("%w: operation %d is missing \"path\"", ErrInvalidPatch, i)
+		}
+		invalid := func(err error) error {
+			return &OperationError{Index: i, Path: *op.Path, Err: fmt.Errorf("%w: %v", ErrInvalidPatch, err)}
+		}
+
+		path, err := parsePointer(*op.Path)
+		if err != nil {
+			return nil, invalid(err)
+		}
+
+		var value any
+		if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+			if op.Value == nil {
+				return nil, invalid(errors.New(`missing "value"`))
+			}
+			if err := decode(*op.Value, &value); err != nil {
+				return nil, invalid(err)
+			}
+		}
+
+		var from []string
+		if op.Op == "move" || op.Op == "copy" {
+			if op.From == nil {
+				return nil, invalid(errors.New(`missing "from"`))
+			}
+			from, err = parsePointer(*op.From)
+			if err != nil {
+				return nil, invalid(err)
+			}
+		}
+
+		switch op.Op {
+		case "add":
+			target, err = add(target, path, value)
+		case "remove":
+			target, _, err = remove(target, path)
+		case "replace":
+			target, _, err = remove(target, path)
+			if err == nil {
+				target, err = add(target, path, value)
+			}
+		case "move":
+			if isPrefix(from, path) && len(from) < len(path) {
+				err = errors.New("cannot move a value into one of its own children")
+				break
+			}
+			var moved any
+			target, moved, err = remove(target, from)
+			if err == nil {
+				target, err = add(target, path, moved)
+			}
+		case "copy":
+			var copied any
+			copied, err = get(target, from)
+			if err == nil {
+				target, err = add(target, path, deepCopy(copied))
+			}
+		case "test":
+			var actual any
+			actual, err = get(target, path)
+			if err != nil || !equal(actual, value) {
+				return nil, &OperationError{Index: i, Path: *op.Path, Err: ErrTestFailed}
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+		if err != nil {
+			return nil, invalid(err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+// decode unmarshals using json.Number so numbers survive a round trip
+// without losing precision.
+func decode(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// equal compares JSON values as RFC 6902 section 4.6 requires for "test":
+// numbers by value, so 4 and 4.0 are equal, and everything else exactly.
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, _, errA := big.ParseFloat(a.String(), 10, 256, big.ToNearestEven)
+		y, _, errB := big.ParseFloat(b.String(), 10, 256, big.ToNearestEven)
+		if errA != nil || errB != nil {
+			return a == b
+		}
+		return x.Cmp(y) == 0
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for key, value := range a {
+			other, ok := b[key]
+			if !ok || !equal(value, other) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%q is not a JSON pointer", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	limit := length - 1
+	if allowEnd {
+		limit = length
+	}
+	if i > limit {
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+	return i, nil
+}
+
+func get(node any, path []string) (any, error) {
+	for _, token := range path {
+		switch n := node.(type) {
+		case map[string]any:
+			value, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("path member %q does not exist", token)
+			}
+			node = value
+		case []any:
+			i, err := arrayIndex(token, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("path member %q does not exist", token)
+		}
+	}
+	return node, nil
+}
+
+// add returns node with value inserted at path. Containers are updated in
+// place except for arrays, whose new slice is written back to the parent.
+func add(node any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(node, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch p := parent.(type) {
+	case map[string]any:
+		p[last] = value
+		return node, nil
+	case []any:
+		i, err := arrayIndex(last, len(p), true)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, nil)
+		copy(p[i+1:], p[i:])
+		p[i] = value
+		return replaceAt(node, path[:len(path)-1], p)
+	default:
+		return nil, fmt.Errorf("cannot add to %q", strings.Join(path, "/"))
+	}
+}
+
+func remove(node any, path []string) (any, any, error) {
+	if len(path) == 0 {
+		return nil, node, nil
+	}
+
+	parent, err := get(node, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := path[len(path)-1]
+
+	switch p := parent.(type) {
+	case map[string]any:
+		value, ok := p[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("path member %q does not exist", last)
+		}
+		delete(p, last)
+		return node, value, nil
+	case []any:
+		i, err := arrayIndex(last, len(p), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		value := p[i]
+		p = append(p[:i:i], p[i+1:]...)
+		node, err = replaceAt(node, path[:len(path)-1], p)
+		return node, value, err
+	default:
+		return nil, nil, fmt.Errorf("path member %q does not exist", last)
+	}
+}
+
+func replaceAt(node any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	parent, err := get(node, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+
+	switch p := parent.(type) {
+	case map[string]any:
+		p[last] = value
+	case []any:
+		i, err := arrayIndex(last, len(p), false)
+		if err != nil {
+			return nil, err
+		}
+		p[i] = value
+	}
+	return node, nil
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		c := make(map[string]any, len(v))
+		for key, item := range v {
+			c[key] = deepCopy(item)
+		}
+		return c
+	case []any:
+		c := make([]any, len(v))
+		for i, item := range v {
+			c[i] = deepCopy(item)
+		}
+		return c
+	default:
+		return v
+	}
+}
@@ -0,0 +1,202 @@
+// Package mailer renders transactional emails from embedded templates and
+// delivers them in the background through a pluggable Sender.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"sync"
+	"text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+const (
+	queueSize   = 100
+	workers     = 2
+	maxAttempts = 4
+	sendTimeout = 30 * time.Second
+)
+
+// ErrQueueFull is returned by Send when the delivery queue has no room.
+var ErrQueueFull = errors.New("mailer: queue full")
+
+// Message is a rendered email, ready for a Sender.
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// Sender delivers one message.
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// Mailer queues messages and delivers them from a pool of workers, retrying
+// failures with exponential backoff, so handlers never wait on the mail
+// server.
+type Mailer struct {
+	sender  Sender
+	from    string
+	logger  *slog.Logger
+	backoff time.Duration
+
+	mu     sync.Mutex
+	closed bool
+	queue  chan *Message
+	wg     sync.WaitGroup
+}
+
+// New starts a Mailer that sends from the given address.
+func New(sender Sender, from string, logger *slog.Logger) *Mailer {
+	m := &Mailer{
+		sender:  sender,
+		from:    from,
+		logger:  logger,
+		backoff: time.Second,
+		queue:   make(chan *Message, queueSize),
+	}
+
+	m.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+
+	return m
+}
+
+// Send renders templateFile from the templates directory with data and
+// queues the message for recipient. Template errors are returned at once;
+// delivery errors are only logged.
+//
+// A template defines "subject", "plainBody" and "htmlContent". The HTML
+// part is htmlContent inside the shared layout.
+func (m *Mailer) Send(recipient, templateFile string, data any) error {
+	msg, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+	msg.From = m.from
+	msg.To = recipient
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errors.New("mailer: closed")
+	}
+
+	select {
+	case m.queue <- msg:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Close stops accepting messages and waits for the queued ones to be
+// delivered or for ctx to end.
+func (m *Mailer) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *Mailer) work() {
+	defer m.wg.Done()
+
+	for msg := range m.queue {
+		m.deliver(msg)
+	}
+}
+
+func (m *Mailer) deliver(msg *Message) {
+	defer func() {
+		if err := recover(); err != nil {
+			m.logger.Error(fmt.Sprint(err), "to", msg.To, "subject", msg.Subject)
+		}
+	}()
+
+	backoff := m.backoff
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := m.sender.Send(ctx, msg)
+		cancel()
+		if err == nil {
+			return
+		}
+
+		if attempt == maxAttempts {
+			m.logger.Error("giving up sending email", "to", msg.To, "subject", msg.Subject, "attempts", attempt, "error", err.Error())
+			return
+		}
+		m.logger.Warn("sending email failed, retrying", "to", msg.To, "subject", msg.Subject, "attempt", attempt, "error", err.Error())
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func render(templateFile string, data any) (*Message, error) {
+	path := "templates/" + templateFile
+
+	tmpl, err := template.New("email").ParseFS(templateFS, path)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(templateFS, "templates/layout.tmpl", path)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Subject:   subject.String(),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}, nil
+}
@@ -0,0 +1,148 @@
+// Package markdown turns user-written Markdown into HTML that is safe to
+// embed in a page, and into plain text for search and excerpts.
+package markdown
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+var (
+	// Raw HTML in the source is never rendered by goldmark; the sanitizer
+	// is a second line of defence and the definitive allow-list.
+	md = goldmark.New(
+		goldmark.WithExtensions(extension.Strikethrough, extension.Linkify),
+		goldmark.WithParserOptions(
+			parser.WithASTTransformers(util.Prioritized(spoilerTransformer{}, 100)),
+		),
+	)
+
+	sanitizer = newSanitizer()
+)
+
+func newSanitizer() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "hr", "em", "strong", "del", "code", "pre",
+		"blockquote", "ul", "ol", "li", "h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^spoiler$`)).OnElements("blockquote")
+	p.AllowAttrs("start").Matching(bluemonday.Integer).OnElements("ol")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.RequireNoFollowOnLinks(true)
+	p.RequireNoReferrerOnLinks(true)
+	return p
+}
+
+// Render returns the sanitized HTML and the plain text of source. Spoiler
+// blocks, written as "> !" quotes, are rendered as
+// <blockquote class="spoiler"> and left out of the text so excerpts don't
+// give anything away.
+func Render(source string) (html string, plain string, err error) {
+	src := spoilerEndRX.ReplaceAll([]byte(source), []byte("$1"))
+	doc := md.Parser().Parse(text.NewReader(src))
+
+	var buf bytes.Buffer
+	err = md.Renderer().Render(&buf, src, doc)
+	if err != nil {
+		return "", "", err
+	}
+
+	return sanitizer.Sanitize(buf.String()), extractText(doc, src), nil
+}
+
+var spoilerClass = []byte("spoiler")
+
+// spoilerEndRX matches the closing "!<" of a ">! spoiler !<" line, which
+// would otherwise be parsed as the start of an HTML tag.
+var spoilerEndRX = regexp.MustCompile(`(?m)^(>.*?)[ \t]*!<[ \t]*$`)
+
+// spoilerTransformer marks blockquotes whose text starts with "!" (the
+// ">! like this !<" convention) as spoilers and strips the opening marker.
+type spoilerTransformer struct{}
+
+func (spoilerTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	src := reader.Source()
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering || n.Kind() != ast.KindBlockquote {
+			return ast.WalkContinue, nil
+		}
+		first, ok := n.FirstChild().(*ast.Paragraph)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+		t, ok := first.FirstChild().(*ast.Text)
+		if !ok || !bytes.HasPrefix(t.Segment.Value(src), []byte("!")) {
+			return ast.WalkContinue, nil
+		}
+
+		segment := t.Segment.WithStart(t.Segment.Start + 1)
+		t.Segment = segment.TrimLeftSpace(src)
+		n.SetAttribute([]byte("class"), spoilerClass)
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+func isSpoiler(n ast.Node) bool {
+	class, ok := n.AttributeString("class")
+	if !ok {
+		return false
+	}
+	b, ok := class.([]byte)
+	return ok && bytes.Equal(b, spoilerClass)
+}
+
+// extractText flattens the document to one line per block, without markup.
+func extractText(doc ast.Node, src []byte) string {
+	var b strings.Builder
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if n.Kind() == ast.KindBlockquote && isSpoiler(n) {
+			return ast.WalkSkipChildren, nil
+		}
+
+		switch n := n.(type) {
+		case *ast.Text:
+			if entering {
+				b.Write(n.Segment.Value(src))
+				if n.SoftLineBreak() || n.HardLineBreak() {
+					b.WriteByte(' ')
+				}
+			}
+		case *ast.AutoLink:
+			if entering {
+				b.Write(n.Label(src))
+			}
+		case *ast.CodeBlock, *ast.FencedCodeBlock:
+			if entering {
+				lines := n.Lines()
+				for i := 0; i < lines.Len(); i++ {
+					segment := lines.At(i)
+					b.Write(segment.Value(src))
+				}
+			}
+		}
+
+		if !entering && n.Type() == ast.TypeBlock {
+			b.WriteByte('\n')
+		}
+		return ast.WalkContinue, nil
+	})
+
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,210 @@
+// Package policy holds the operator-configurable content rules for reviews.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/tchenbz/AWTtest3/internal/validator"
+)
+
+const (
+	AutoApproveAll     = "all"
+	AutoApproveNone    = "none"
+	AutoApproveTrusted = "trusted"
+)
+
+var linkRX = regexp.MustCompile(`(?i)\b(?:https?://|www\.)`)
+
+// Review is the content policy applied to reviews on create and update.
+type Review struct {
+	Rating struct {
+		Min int `json:"min"`
+		Max int `json:"max"`
+	} `json:"rating"`
+	Content struct {
+		MinLength int `json:"min_length"`
+		MaxLength int `json:"max_length"`
+	} `json:"content"`
+	MaxLinks int `json:"max_links"`
+	Caps     struct {
+		// MaxRatio is the largest share of upper-case letters allowed,
+		// checked only once the content has at least MinLetters letters.
+		MaxRatio   float64 `json:"max_ratio"`
+		MinLetters int     `json:"min_letters"`
+	} `json:"caps"`
+	BannedWords []string `json:"banned_words"`
+	// AutoApprove decides which new or edited reviews skip the moderation
+	// queue: "all", "none", or "trusted" for authors the caller marks as
+	// trusted.
+	AutoApprove string `json:"auto_approve"`
+	// Flags weights each reader report by its reason. A review is hidden
+	// once the weights of its unresolved flags add up to HideThreshold.
+	Flags struct {
+		Weights       map[string]float64 `json:"weights"`
+		HideThreshold float64            `json:"hide_threshold"`
+	} `json:"flags"`
+
+	banned map[string]bool
+}
+
+// DefaultReview is used when no policy file is configured.
+func DefaultReview() *Review {
+	p := &Review{MaxLinks: 3}
+	p.Rating.Min, p.Rating.Max = 1, 5
+	p.Content.MinLength, p.Content.MaxLength = 1, 5000
+	p.Caps.MaxRatio, p.Caps.MinLetters = 0.7, 20
+	p.AutoApprove = AutoApproveTrusted
+	p.Flags.Weights = map[string]float64{"spam": 1, "offensive": 2, "spoiler": 1}
+	p.Flags.HideThreshold = 5
+	p.compile()
+	return p
+}
+
+// LoadReview reads a JSON policy file. Settings missing from the file keep
+// their DefaultReview values.
+func LoadReview(path string) (*Review, error) {
+	js, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := DefaultReview()
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(p)
+	if err != nil {
+		return nil, fmt.Errorf("policy: %s: %w", path, err)
+	}
+
+	switch {
+	case p.Rating.Min > p.Rating.Max:
+		return nil, errors.New("policy: rating.min must not exceed rating.max")
+	case p.Content.MinLength < 0 || p.Content.MinLength > p.Content.MaxLength:
+		return nil, errors.New("policy: content.min_length must be between 0 and content.max_length")
+	case p.MaxLinks < 0:
+		return nil, errors.New("policy: max_links must not be negative")
+	case p.Caps.MaxRatio <= 0 || p.Caps.MaxRatio > 1:
+		return nil, errors.New("policy: caps.max_ratio must be greater than 0 and at most 1")
+	case p.AutoApprove != AutoApproveAll && p.AutoApprove != AutoApproveNone && p.AutoApprove != AutoApproveTrusted:
+		return nil, errors.New(`policy: auto_approve must be "all", "none" or "trusted"`)
+	case p.Flags.HideThreshold <= 0:
+		return nil, errors.New("policy: flags.hide_threshold must be greater than 0")
+	}
+	for reason, weight := range p.Flags.Weights {
+		if weight < 0 {
+			return nil, fmt.Errorf("policy: flags.weights.%s must not be negative", reason)
+		}
+	}
+
+	p.compile()
+	return p, nil
+}
+
+func (p *Review) compile() {
+	p.banned = make(map[string]bool, len(p.BannedWords))
+	for _, word := range p.BannedWords {
+		p.banned[strings.ToLower(strings.TrimSpace(word))] = true
+	}
+}
+
+// Check records a reason under "rating" or "content" for every rule the
+// review breaks.
+func (p *Review) Check(v *validator.Validator, content string, rating int) {
+	v.Check(validator.InRange(rating, p.Rating.Min, p.Rating.Max), "rating", "range", "min", p.Rating.Min, "max", p.Rating.Max)
+	p.CheckContent(v, "content", content)
+}
+
+// CheckContent applies the content rules alone, recording reasons under key.
+// Comments use it so they are held to the same standard as reviews.
+func (p *Review) CheckContent(v *validator.Validator, key, content string) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		v.AddError(key, "required")
+		return
+	}
+	v.Check(validator.MinRunes(content, p.Content.MinLength), key, "min_len", "min", p.Content.MinLength)
+	v.Check(validator.MaxRunes(content, p.Content.MaxLength), key, "max_len", "max", p.Content.MaxLength)
+
+	links := len(linkRX.FindAllStringIndex(content, -1))
+	v.Check(links <= p.MaxLinks, key, "too_many_links", "max", p.MaxLinks)
+
+	letters, upper := 0, 0
+	for _, r := range content {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters >= p.Caps.MinLetters && letters > 0 {
+		ratio := float64(upper) / float64(letters)
+		v.Check(ratio <= p.Caps.MaxRatio, key, "too_much_caps", "max_percent", int(p.Caps.MaxRatio*100))
+	}
+
+	if len(p.banned) > 0 {
+		words := strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+		})
+		for _, word := range words {
+			if p.banned[word] {
+				v.AddError(key, "banned_word", "word", word)
+				break
+			}
+		}
+	}
+}
+
+// Approves reports whether a review by an author with the given trust can be
+// published without waiting for a moderator.
+func (p *Review) Approves(trusted bool) bool {
+	switch p.AutoApprove {
+	case AutoApproveAll:
+		return true
+	case AutoApproveTrusted:
+		return trusted
+	default:
+		return false
+	}
+}
+
+// Engine holds the active review policy and swaps in a new one on Reload,
+// so operators can change rules without restarting the server.
+type Engine struct {
+	path    string
+	current atomic.Pointer[Review]
+}
+
+// NewEngine loads the policy at path, or uses DefaultReview if path is empty.
+func NewEngine(path string) (*Engine, error) {
+	e := &Engine{path: path}
+	return e, e.Reload()
+}
+
+// Reload re-reads the policy file. If the file is invalid the previous policy
+// stays in force and the error is returned.
+func (e *Engine) Reload() error {
+	if e.path == "" {
+		e.current.Store(DefaultReview())
+		return nil
+	}
+
+	p, err := LoadReview(e.path)
+	if err != nil {
+		return err
+	}
+	e.current.Store(p)
+	return nil
+}
+
+func (e *Engine) Review() *Review {
+	return e.current.Load()
+}
@@ -0,0 +1,92 @@
+package validator
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// MinRunes reports whether s has at least n characters. Length is counted
+// in runes, not bytes, so accented and non-Latin text is measured fairly.
+func MinRunes(s string, n int) bool {
+	return utf8.RuneCountInString(s) >= n
+}
+
+func MaxRunes(s string, n int) bool {
+	return utf8.RuneCountInString(s) <= n
+}
+
+func InRange[T int | int64 | float64](value, min, max T) bool {
+	return value >= min && value <= max
+}
+
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
+
+func IsEmail(value string) bool {
+	return len(value) <= 254 && EmailRX.MatchString(value)
+}
+
+// IsURL accepts absolute http and https URLs with a host.
+func IsURL(value string) bool {
+	u, err := url.ParseRequestURI(value)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// IsISBN accepts ISBN-10 and ISBN-13 values, with or without hyphens and
+// spaces, and verifies the check digit.
+func IsISBN(value string) bool {
+	digits := strings.NewReplacer("-", "", " ", "").Replace(value)
+
+	switch len(digits) {
+	case 10:
+		sum := 0
+		for i, c := range digits {
+			var d int
+			switch {
+			case c >= '0' && c <= '9':
+				d = int(c - '0')
+			case (c == 'X' || c == 'x') && i == 9:
+				d = 10
+			default:
+				return false
+			}
+			sum += d * (10 - i)
+		}
+		return sum%11 == 0
+	case 13:
+		sum := 0
+		for i, c := range digits {
+			if c < '0' || c > '9' {
+				return false
+			}
+			d := int(c - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		return sum%10 == 0
+	default:
+		return false
+	}
+}
+
+// Unique reports whether values contains no duplicates.
+func Unique[T comparable](values []T) bool {
+	seen := make(map[T]bool, len(values))
+	for _, value := range values {
+		if seen[value] {
+			return false
+		}
+		seen[value] = true
+	}
+	return true
+}
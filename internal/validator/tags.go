@@ -0,0 +1,284 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Rule is a named check usable in `validate` struct tags. Check receives the
+// field value and the text after "=" in the tag (empty if there is none).
+// Params turns that text into the message params; when nil the message gets
+// a single "param" value.
+type Rule struct {
+	Check  func(value reflect.Value, param string) bool
+	Params func(param string) []any
+}
+
+var (
+	rulesMu sync.RWMutex
+	rules   = map[string]Rule{
+		"min_len":   {Check: checkLength(func(n, limit int) bool { return n >= limit }), Params: namedParam("min")},
+		"max_len":   {Check: checkLength(func(n, limit int) bool { return n <= limit }), Params: namedParam("max")},
+		"min_value": {Check: checkNumber(func(n, limit float64) bool { return n >= limit }), Params: namedParam("min")},
+		"max_value": {Check: checkNumber(func(n, limit float64) bool { return n <= limit }), Params: namedParam("max")},
+		"range":     {Check: checkRange, Params: rangeParams},
+		"regexp":    {Check: checkRegexp, Params: func(string) []any { return nil }},
+		"email":     {Check: checkString(IsEmail)},
+		"url":       {Check: checkString(IsURL)},
+		"isbn":      {Check: checkString(IsISBN)},
+		"one_of":    {Check: checkOneOf, Params: oneOfParams},
+		"unique":    {Check: checkUnique},
+	}
+	regexps sync.Map
+)
+
+// RegisterRule adds or replaces a rule for use in struct tags. The rule's
+// name is also the code of the message it produces.
+func RegisterRule(name string, rule Rule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules[name] = rule
+}
+
+// Struct validates s, a struct or pointer to one, against its `validate`
+// tags, e.g.
+//
+//	Title string `json:"title" validate:"required,max_len=500"`
+//	Tags  []string `json:"tags" validate:"max_len=10,unique"`
+//
+// Error keys use the json names, and nested structs and slices of structs
+// are walked with keys such as "authors[2].name". Apart from "required",
+// rules skip zero values, so optional fields only need to be valid when set.
+// Rule parameters cannot contain commas.
+func (v *Validator) Struct(s any) {
+	v.walk(reflect.ValueOf(s), "")
+}
+
+func (v *Validator) walk(value reflect.Value, prefix string) {
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return
+	}
+
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := fieldName(field)
+		if name == "" {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		fieldValue := value.Field(i)
+
+		if tag := field.Tag.Get("validate"); tag != "" {
+			v.applyTag(tag, key, fieldValue)
+		}
+
+		elem := fieldValue
+		for elem.Kind() == reflect.Pointer && !elem.IsNil() {
+			elem = elem.Elem()
+		}
+		switch elem.Kind() {
+		case reflect.Struct:
+			v.walk(elem, key)
+		case reflect.Slice, reflect.Array:
+			for j := 0; j < elem.Len(); j++ {
+				v.walk(elem.Index(j), fmt.Sprintf("%s[%d]", key, j))
+			}
+		}
+	}
+}
+
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	default:
+		return name
+	}
+}
+
+func (v *Validator) applyTag(tag, key string, value reflect.Value) {
+	for _, part := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(part), "=")
+
+		if name == "required" {
+			v.Check(!isZero(value), key, "required")
+			continue
+		}
+		if isZero(value) {
+			continue
+		}
+
+		rulesMu.RLock()
+		rule, ok := rules[name]
+		rulesMu.RUnlock()
+		if !ok {
+			panic("validator: unknown rule " + strconv.Quote(name))
+		}
+
+		if !rule.Check(value, param) {
+			var params []any
+			if rule.Params != nil {
+				params = rule.Params(param)
+			} else if param != "" {
+				params = []any{"param", param}
+			}
+			v.AddError(key, name, params...)
+		}
+	}
+}
+
+func isZero(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	default:
+		return value.IsZero()
+	}
+}
+
+func indirect(value reflect.Value) reflect.Value {
+	for value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+	return value
+}
+
+func namedParam(name string) func(string) []any {
+	return func(param string) []any {
+		return []any{name, param}
+	}
+}
+
+func checkString(fn func(string) bool) func(reflect.Value, string) bool {
+	return func(value reflect.Value, _ string) bool {
+		value = indirect(value)
+		return value.Kind() == reflect.String && fn(value.String())
+	}
+}
+
+// checkLength measures strings in runes and slices, arrays and maps in
+// elements.
+func checkLength(cmp func(n, limit int) bool) func(reflect.Value, string) bool {
+	return func(value reflect.Value, param string) bool {
+		limit, err := strconv.Atoi(param)
+		if err != nil {
+			panic("validator: length rule needs an integer parameter, got " + strconv.Quote(param))
+		}
+
+		value = indirect(value)
+		switch value.Kind() {
+		case reflect.String:
+			return cmp(utf8.RuneCountInString(value.String()), limit)
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return cmp(value.Len(), limit)
+		default:
+			return false
+		}
+	}
+}
+
+func number(value reflect.Value) (float64, bool) {
+	value = indirect(value)
+	switch {
+	case value.CanInt():
+		return float64(value.Int()), true
+	case value.CanUint():
+		return float64(value.Uint()), true
+	case value.CanFloat():
+		return value.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+func checkNumber(cmp func(n, limit float64) bool) func(reflect.Value, string) bool {
+	return func(value reflect.Value, param string) bool {
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			panic("validator: numeric rule needs a number parameter, got " + strconv.Quote(param))
+		}
+		n, ok := number(value)
+		return ok && cmp(n, limit)
+	}
+}
+
+// checkRange takes "min:max", inclusive at both ends.
+func checkRange(value reflect.Value, param string) bool {
+	lo, hi, ok := strings.Cut(param, ":")
+	min, err1 := strconv.ParseFloat(lo, 64)
+	max, err2 := strconv.ParseFloat(hi, 64)
+	if !ok || err1 != nil || err2 != nil {
+		panic("validator: range rule needs a min:max parameter, got " + strconv.Quote(param))
+	}
+	n, ok := number(value)
+	return ok && InRange(n, min, max)
+}
+
+func rangeParams(param string) []any {
+	lo, hi, _ := strings.Cut(param, ":")
+	return []any{"min", lo, "max", hi}
+}
+
+func checkRegexp(value reflect.Value, param string) bool {
+	rx, ok := regexps.Load(param)
+	if !ok {
+		rx, _ = regexps.LoadOrStore(param, regexp.MustCompile(param))
+	}
+	return checkString(func(s string) bool { return Matches(s, rx.(*regexp.Regexp)) })(value, param)
+}
+
+// checkOneOf takes the permitted values separated by spaces.
+func checkOneOf(value reflect.Value, param string) bool {
+	return checkString(func(s string) bool {
+		return PermittedValue(s, strings.Fields(param)...)
+	})(value, param)
+}
+
+func oneOfParams(param string) []any {
+	return []any{"values", strings.Join(strings.Fields(param), ", ")}
+}
+
+func checkUnique(value reflect.Value, _ string) bool {
+	value = indirect(value)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return false
+	}
+
+	seen := make(map[any]bool, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		elem := value.Index(i)
+		if !elem.Comparable() {
+			return false
+		}
+		key := elem.Interface()
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+	}
+	return true
+}
@@ -1,16 +1,41 @@
 package validator
 
 import (
+	"fmt"
 	"slices"
+	"strings"
 )
+
+// Message is a validation error identified by a stable code, with the values
+// needed to render it in any language.
+type Message struct {
+    Code   string         `json:"code"`
+    Params map[string]any `json:"params,omitempty"`
+}
+
+// NewMessage builds a Message from a code and alternating name, value params,
+// e.g. NewMessage("max_value", "max", 100).
+func NewMessage(code string, params ...any) Message {
+    m := Message{Code: code}
+    if len(params) > 1 {
+        m.Params = make(map[string]any, len(params)/2)
+        for i := 0; i+1 < len(params); i += 2 {
+            name, _ := params[i].(string)
+            m.Params[name] = params[i+1]
+        }
+    }
+    return m
+}
  
+// Validator collects every failed check, so a field can carry several
+// messages at once.
 type Validator struct {
-    Errors map[string]string
+    Errors map[string][]Message
 } 
 
 func New() *Validator {
     return &Validator {
-        Errors: make(map[string]string),
+        Errors: make(map[string][]Message),
     }
 }
 
@@ -18,17 +43,40 @@ func (v *Validator) IsEmpty() bool {
     return len(v.Errors) == 0
 }
 
-func (v *Validator) AddError(key string, message string) {
-    _, exists := v.Errors[key]
-    if !exists {
-        v.Errors[key] = message
+// AddError records a message for key. Adding the same code twice for one key
+// is a no-op.
+func (v *Validator) AddError(key string, code string, params ...any) {
+    for _, existing := range v.Errors[key] {
+        if existing.Code == code {
+            return
+        }
     }
+    v.Errors[key] = append(v.Errors[key], NewMessage(code, params...))
 }
 
-func (v *Validator) Check(acceptable bool, key string, message string) {
+func (v *Validator) Check(acceptable bool, key string, code string, params ...any) {
     if !acceptable {
-       v.AddError(key, message)
+       v.AddError(key, code, params...)
+    }
+}
+
+// Key joins path elements into a nested error key: strings become dotted
+// members and ints become indexes, so Key("authors", 2, "name") is
+// "authors[2].name".
+func Key(parts ...any) string {
+    var b strings.Builder
+    for _, part := range parts {
+        switch part := part.(type) {
+        case int:
+            fmt.Fprintf(&b, "[%d]", part)
+        default:
+            if b.Len() > 0 {
+                b.WriteByte('.')
+            }
+            fmt.Fprint(&b, part)
+        }
     }
+    return b.String()
 }
 
 func PermittedValue(value string, permittedValues ...string) bool {
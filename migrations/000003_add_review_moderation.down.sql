@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS reviews_user_id_idx;
+DROP INDEX IF EXISTS reviews_status_idx;
+
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_status_check;
+
+ALTER TABLE reviews
+    DROP COLUMN IF EXISTS moderated_at,
+    DROP COLUMN IF EXISTS moderated_by,
+    DROP COLUMN IF EXISTS rejection_reason,
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS user_id;
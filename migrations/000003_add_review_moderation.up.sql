@@ -0,0 +1,15 @@
+-- Reviews written before moderation existed stay published.
+ALTER TABLE reviews
+    ADD COLUMN IF NOT EXISTS user_id bigint,
+    ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'approved',
+    ADD COLUMN IF NOT EXISTS rejection_reason text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS moderated_by bigint,
+    ADD COLUMN IF NOT EXISTS moderated_at timestamp(0) with time zone;
+
+ALTER TABLE reviews ALTER COLUMN status SET DEFAULT 'pending';
+
+ALTER TABLE reviews ADD CONSTRAINT reviews_status_check
+    CHECK (status IN ('pending', 'approved', 'rejected'));
+
+CREATE INDEX IF NOT EXISTS reviews_status_idx ON reviews (status, id);
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS review_flags;
+
+ALTER TABLE reviews DROP COLUMN IF EXISTS hidden;
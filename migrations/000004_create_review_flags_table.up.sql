@@ -0,0 +1,14 @@
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS hidden boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS review_flags (
+    id bigserial PRIMARY KEY,
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    user_id bigint NOT NULL,
+    reason text NOT NULL CHECK (reason IN ('spam', 'offensive', 'spoiler')),
+    comment text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    resolved_at timestamp(0) with time zone,
+    UNIQUE (review_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS review_flags_unresolved_idx ON review_flags (review_id) WHERE resolved_at IS NULL;
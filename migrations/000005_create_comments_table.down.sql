@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS comments;
+
+ALTER TABLE reviews DROP COLUMN IF EXISTS comment_count;
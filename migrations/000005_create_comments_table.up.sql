@@ -0,0 +1,21 @@
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS comment_count integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS comments (
+    id bigserial PRIMARY KEY,
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    parent_id bigint REFERENCES comments ON DELETE CASCADE,
+    user_id bigint NOT NULL,
+    content text NOT NULL,
+    depth integer NOT NULL DEFAULT 0 CHECK (depth >= 0),
+    status text NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+    rejection_reason text NOT NULL DEFAULT '',
+    moderated_by bigint,
+    moderated_at timestamp(0) with time zone,
+    deleted_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS comments_review_id_idx ON comments (review_id, id);
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id);
+CREATE INDEX IF NOT EXISTS comments_status_idx ON comments (status, id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS review_reactions;
+
+ALTER TABLE reviews DROP COLUMN IF EXISTS reaction_counts;
@@ -0,0 +1,9 @@
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS reaction_counts jsonb NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS review_reactions (
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    user_id bigint NOT NULL,
+    reaction text NOT NULL CHECK (reaction IN ('like', 'funny', 'insightful', 'sad')),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (review_id, user_id, reaction)
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS reviews_publish_at_idx;
+
+ALTER TABLE reviews
+    DROP COLUMN IF EXISTS publish_at,
+    DROP COLUMN IF EXISTS draft;
@@ -0,0 +1,11 @@
+ALTER TABLE reviews
+    ADD COLUMN IF NOT EXISTS draft boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS publish_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS reviews_publish_at_idx ON reviews (publish_at) WHERE draft;
+
+-- average_rating is now derived from the visible reviews of each book.
+UPDATE books SET average_rating = COALESCE((
+    SELECT AVG(rating) FROM reviews
+    WHERE reviews.book_id = books.id AND status = 'approved' AND NOT hidden AND NOT draft
+), 0);
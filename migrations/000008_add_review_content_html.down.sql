@@ -0,0 +1,3 @@
+ALTER TABLE reviews
+    DROP COLUMN IF EXISTS content_text,
+    DROP COLUMN IF EXISTS content_html;
@@ -0,0 +1,10 @@
+ALTER TABLE reviews
+    ADD COLUMN IF NOT EXISTS content_html text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS content_text text NOT NULL DEFAULT '';
+
+-- Existing reviews were written as plain text, so render them as a single
+-- escaped paragraph rather than as Markdown.
+UPDATE reviews SET
+    content_text = content,
+    content_html = '<p>' || replace(replace(replace(replace(replace(content,
+        '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;') || '</p>';
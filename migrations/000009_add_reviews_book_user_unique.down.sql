@@ -0,0 +1 @@
+DROP INDEX IF EXISTS reviews_book_id_user_id_idx;
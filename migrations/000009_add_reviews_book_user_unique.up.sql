@@ -0,0 +1,12 @@
+-- A user may review a book only once. Where someone already has several
+-- reviews of a book, the newest stays theirs and the older ones are kept
+-- but no longer attributed to them.
+UPDATE reviews SET user_id = NULL
+WHERE user_id IS NOT NULL AND id NOT IN (
+    SELECT DISTINCT ON (book_id, user_id) id
+    FROM reviews
+    WHERE user_id IS NOT NULL
+    ORDER BY book_id, user_id, id DESC
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS reviews_book_id_user_id_idx ON reviews (book_id, user_id);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS shelf_books;
+DROP TABLE IF EXISTS shelves;
+
+ALTER TABLE books DROP COLUMN IF EXISTS read_count;
+ALTER TABLE books DROP COLUMN IF EXISTS currently_reading_count;
+ALTER TABLE books DROP COLUMN IF EXISTS want_to_read_count;
@@ -0,0 +1,29 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS want_to_read_count integer NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS currently_reading_count integer NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS read_count integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS shelves (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    name text NOT NULL,
+    slug text NOT NULL,
+    exclusive boolean NOT NULL DEFAULT false,
+    public boolean NOT NULL DEFAULT true,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    UNIQUE (user_id, slug)
+);
+
+-- exclusive is copied from the shelf so the partial index can keep a book
+-- on at most one of a user's built-in shelves.
+CREATE TABLE IF NOT EXISTS shelf_books (
+    shelf_id bigint NOT NULL REFERENCES shelves ON DELETE CASCADE,
+    book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+    user_id bigint NOT NULL,
+    exclusive boolean NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (shelf_id, book_id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS shelf_books_exclusive_idx ON shelf_books (user_id, book_id) WHERE exclusive;
+CREATE INDEX IF NOT EXISTS shelf_books_book_id_idx ON shelf_books (book_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS reading_goals;
+DROP TABLE IF EXISTS reading_sessions;
+
+ALTER TABLE books DROP COLUMN IF EXISTS page_count;
@@ -0,0 +1,28 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS page_count integer NOT NULL DEFAULT 0 CHECK (page_count >= 0);
+
+CREATE TABLE IF NOT EXISTS reading_sessions (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+    page integer NOT NULL DEFAULT 0 CHECK (page >= 0),
+    percent numeric(5, 2) NOT NULL DEFAULT 0 CHECK (percent BETWEEN 0 AND 100),
+    reread boolean NOT NULL DEFAULT false,
+    started_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    finished_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+-- A user reads a book once at a time; a re-read starts a new session after
+-- the previous one is finished.
+CREATE UNIQUE INDEX IF NOT EXISTS reading_sessions_active_idx ON reading_sessions (user_id, book_id) WHERE finished_at IS NULL;
+CREATE INDEX IF NOT EXISTS reading_sessions_finished_at_idx ON reading_sessions (user_id, finished_at) WHERE finished_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS reading_goals (
+    user_id bigint NOT NULL,
+    year integer NOT NULL,
+    goal integer NOT NULL CHECK (goal > 0),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1,
+    PRIMARY KEY (user_id, year)
+);
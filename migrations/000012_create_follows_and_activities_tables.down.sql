@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS timelines;
+DROP TABLE IF EXISTS activities;
+DROP TABLE IF EXISTS follows;
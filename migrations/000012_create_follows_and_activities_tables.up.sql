@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS follows (
+    follower_id bigint NOT NULL,
+    followee_id bigint NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX IF NOT EXISTS follows_followee_id_idx ON follows (followee_id);
+
+CREATE TABLE IF NOT EXISTS activities (
+    id bigserial PRIMARY KEY,
+    actor_id bigint NOT NULL,
+    verb text NOT NULL CHECK (verb IN ('review', 'rating', 'shelve')),
+    book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+    review_id bigint REFERENCES reviews ON DELETE CASCADE,
+    shelf_id bigint REFERENCES shelves ON DELETE CASCADE,
+    rating integer NOT NULL DEFAULT 0,
+    previous_rating integer NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- A review is announced once, however often it is re-moderated.
+CREATE UNIQUE INDEX IF NOT EXISTS activities_review_idx ON activities (review_id) WHERE verb = 'review';
+CREATE INDEX IF NOT EXISTS activities_actor_id_idx ON activities (actor_id, id);
+
+-- Each follower's copy of the activity they should see, written when the
+-- activity happens so a feed read is one index range scan.
+CREATE TABLE IF NOT EXISTS timelines (
+    user_id bigint NOT NULL,
+    activity_id bigint NOT NULL REFERENCES activities ON DELETE CASCADE,
+    actor_id bigint NOT NULL,
+    PRIMARY KEY (user_id, activity_id)
+);
+
+CREATE INDEX IF NOT EXISTS timelines_user_id_actor_id_idx ON timelines (user_id, actor_id);
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    type text NOT NULL CHECK (type IN ('comment', 'reply', 'reaction', 'helpful')),
+    book_id bigint NOT NULL REFERENCES books ON DELETE CASCADE,
+    review_id bigint NOT NULL REFERENCES reviews ON DELETE CASCADE,
+    comment_id bigint NOT NULL DEFAULT 0,
+    actor_ids bigint[] NOT NULL,
+    read_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Events about the same subject coalesce into the one unread notification
+-- for it; once that is read the next event starts a new one.
+CREATE UNIQUE INDEX IF NOT EXISTS notifications_unread_subject_idx ON notifications (user_id, type, review_id, comment_id) WHERE read_at IS NULL;
+CREATE INDEX IF NOT EXISTS notifications_user_id_idx ON notifications (user_id, updated_at);
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id bigint NOT NULL,
+    type text NOT NULL CHECK (type IN ('comment', 'reply', 'reaction', 'helpful')),
+    enabled boolean NOT NULL,
+    PRIMARY KEY (user_id, type)
+);
@@ -0,0 +1,20 @@
+CREATE EXTENSION IF NOT EXISTS citext;
+
+CREATE TABLE IF NOT EXISTS users (
+    id bigserial PRIMARY KEY,
+    email citext UNIQUE NOT NULL,
+    password_hash bytea NOT NULL,
+    -- Bearer tokens issued before this time are no longer accepted.
+    sessions_revoked_at timestamp(0) with time zone,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE TABLE IF NOT EXISTS tokens (
+    hash bytea PRIMARY KEY,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    expiry timestamp(0) with time zone NOT NULL,
+    scope text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS tokens_user_id_idx ON tokens (user_id, scope);
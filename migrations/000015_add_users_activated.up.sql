@@ -0,0 +1,21 @@
+-- Accounts created before activation existed count as activated.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS activated boolean NOT NULL DEFAULT true;
+ALTER TABLE users ALTER COLUMN activated SET DEFAULT false;
+
+-- users.id is the sub of bearer tokens. User IDs already in use from tokens
+-- signed elsewhere must not be handed to new accounts, so start the sequence
+-- after the highest one seen. Existing users get an account by inserting
+-- them with their current ID.
+SELECT setval('users_id_seq', m)
+FROM (
+    SELECT GREATEST(
+        (SELECT MAX(id) FROM users),
+        (SELECT MAX(user_id) FROM reviews),
+        (SELECT MAX(user_id) FROM comments),
+        (SELECT MAX(user_id) FROM shelves),
+        (SELECT MAX(user_id) FROM reading_sessions),
+        (SELECT MAX(follower_id) FROM follows),
+        (SELECT MAX(followee_id) FROM follows)
+    ) AS m
+) AS highest
+WHERE m IS NOT NULL;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    hash bytea NOT NULL UNIQUE,
+    scopes text[] NOT NULL,
+    expires_at timestamp(0) with time zone,
+    last_used_at timestamp(0) with time zone,
+    -- A rotated key keeps working until the end of its overlap window.
+    revoked_at timestamp(0) with time zone,
+    replaced_by bigint REFERENCES api_keys,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);